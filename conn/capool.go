@@ -0,0 +1,112 @@
+package conn
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// appendPEMCerts adds all certificates from PEM data to pool and returns
+// number of certificates added.
+func appendPEMCerts(pool *x509.CertPool, data []byte) (int, error) {
+	count := 0
+	for len(data) > 0 {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" || len(block.Headers) != 0 {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return count, err
+		}
+		pool.AddCert(cert)
+		count++
+	}
+	return count, nil
+}
+
+// caDirFiles lists regular files in CA directory.
+func caDirFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, entry := range entries {
+		name := filepath.Join(dir, entry.Name())
+		// Stat follows symlinks, which are common in hashed CA directories
+		fi, err := os.Stat(name)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		res = append(res, name)
+	}
+	return res, nil
+}
+
+// loadCAPool builds root CA pool from files and directories, optionally
+// on top of system roots. It returns nil pool if nothing was specified,
+// which means system roots.
+func (l *materialLoader) loadCAPool() (*x509.CertPool, error) {
+	if len(l.caFiles) == 0 && len(l.caDirs) == 0 {
+		return nil, nil
+	}
+	var pool *x509.CertPool
+	if l.caSystem {
+		var err error
+		pool, err = x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("unable to load system CA certificates: %w", err)
+		}
+		l.logger.Info("Using system CA certificates along with custom CA certificates.")
+	} else {
+		pool = x509.NewCertPool()
+	}
+	total := 0
+	for _, name := range l.caFiles {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		count, err := appendPEMCerts(pool, data)
+		if err != nil {
+			return nil, fmt.Errorf("bad CA certificate in file %q: %w", name, err)
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("Failed to load CA certificates from file %q", name)
+		}
+		l.logger.Info("Loaded %d CA certificates from file %q", count, name)
+		total += count
+	}
+	for _, dir := range l.caDirs {
+		files, err := caDirFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+		dirCount := 0
+		for _, name := range files {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return nil, err
+			}
+			// Directories may contain unrelated files, CRLs and so on.
+			count, err := appendPEMCerts(pool, data)
+			if err != nil {
+				l.logger.Warning("Skipping bad CA certificate in file %q: %v", name, err)
+			}
+			dirCount += count
+		}
+		l.logger.Info("Loaded %d CA certificates from directory %q", dirCount, dir)
+		total += dirCount
+	}
+	if total == 0 && !l.caSystem {
+		return nil, fmt.Errorf("no CA certificates were loaded")
+	}
+	return pool, nil
+}
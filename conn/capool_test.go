@@ -0,0 +1,113 @@
+package conn
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCADir(t *testing.T) {
+	first, second, other := newTestPKI(t), newTestPKI(t), newTestPKI(t)
+	certPEM := func(pki *testPKI) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pki.ca.Raw})
+	}
+	crlPEM := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: first.crl(t).Raw})
+	badPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")})
+	outside := filepath.Join(t.TempDir(), "second.pem")
+	if err := os.WriteFile(outside, certPEM(second), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		files    map[string][]byte
+		symlinks map[string]string
+		trusted  []*testPKI
+		wantErr  bool
+	}{
+		{"PEM files", map[string][]byte{
+			"first.pem":  certPEM(first),
+			"second.crt": certPEM(second),
+		}, nil, []*testPKI{first, second}, false},
+		{"non-PEM files skipped", map[string][]byte{
+			"first.pem":  certPEM(first),
+			"README":     []byte("CA certificates for upstream\n"),
+			"first.der":  first.ca.Raw,
+			"crl.pem":    crlPEM,
+			"broken.pem": badPEM,
+		}, nil, []*testPKI{first}, false},
+		{"hashed symlinks", map[string][]byte{
+			"first.pem": certPEM(first),
+		}, map[string]string{
+			"1a2b3c4d.0": outside,
+			"dangling.0": filepath.Join(t.TempDir(), "missing.pem"),
+		}, []*testPKI{first, second}, false},
+		{"only non-PEM files", map[string][]byte{
+			"README":    []byte("nothing here\n"),
+			"first.der": first.ca.Raw,
+			"crl.pem":   crlPEM,
+		}, nil, nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.Mkdir(filepath.Join(dir, "subdir"), 0700); err != nil {
+				t.Fatal(err)
+			}
+			for name, data := range tc.files {
+				if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for name, target := range tc.symlinks {
+				if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+					t.Skip(err)
+				}
+			}
+			l := &materialLoader{caDirs: []string{dir}, logger: testLogger()}
+			pool, err := l.loadCAPool()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil {
+				return
+			}
+			for _, pki := range tc.trusted {
+				if _, err := pki.leaf.Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+					t.Errorf("CA %v not loaded: %v", pki.ca.Subject, err)
+				}
+			}
+			if _, err := other.leaf.Verify(x509.VerifyOptions{Roots: pool}); err == nil {
+				t.Error("unrelated CA trusted")
+			}
+		})
+	}
+}
+
+func TestHostnameCheckWithSystemCA(t *testing.T) {
+	pki := newTestPKI(t)
+	caFile := writeCAFile(t, pki)
+	pin := testPin(pki.leaf)
+	for _, tc := range []struct {
+		name    string
+		opts    TLSOptions
+		wantErr bool
+	}{
+		{"custom CA only", TLSOptions{CAFiles: []string{caFile}}, false},
+		{"custom and system CA", TLSOptions{CAFiles: []string{caFile}, CASystem: true}, true},
+		{"system CA with pin", TLSOptions{CAFiles: []string{caFile}, CASystem: true, Pins: []string{pin}}, false},
+		{"system CA with identity", TLSOptions{CAFiles: []string{caFile}, CASystem: true,
+			ServerIdentity: &ServerIdentity{DNSNames: []string{"server.example.com"}}}, false},
+		{"system CA with empty identity", TLSOptions{CAFiles: []string{caFile}, CASystem: true,
+			ServerIdentity: &ServerIdentity{}}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.opts.Dialers = 1
+			_, err := NewTLSConnFactory("localhost", 443, nil, tc.opts, testLogger())
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package conn
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type DANEMode int
+
+const (
+	DANEOff DANEMode = iota
+	// DANEWithCA requires CA validation and, if secure TLSA records are
+	// published, match against them
+	DANEWithCA
+	// DANEOnly requires match against secure TLSA records instead of
+	// CA validation
+	DANEOnly
+)
+
+func ParseDANEMode(s string) (DANEMode, error) {
+	switch strings.ToLower(s) {
+	case "off", "":
+		return DANEOff, nil
+	case "ca":
+		return DANEWithCA, nil
+	case "only":
+		return DANEOnly, nil
+	default:
+		return 0, fmt.Errorf("unknown DANE mode %q", s)
+	}
+}
+
+const (
+	tlsaUsageDANETA = 2
+	tlsaUsageDANEEE = 3
+
+	DANENegativeCacheTTL = 30 * time.Second
+	DANEMaxCacheTTL      = 5 * time.Minute
+)
+
+// DefaultDNSServer returns first nameserver from system resolver
+// configuration.
+func DefaultDNSServer() (string, error) {
+	cfg, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	if len(cfg.Servers) == 0 {
+		return "", errors.New("no nameservers in resolv.conf")
+	}
+	return net.JoinHostPort(cfg.Servers[0], cfg.Port), nil
+}
+
+// errTLSALookup marks failed TLSA queries. Such failures are transient
+// and are not reported as certificate verification errors.
+var errTLSALookup = errors.New("TLSA lookup failed")
+
+type tlsaResult struct {
+	records []*dns.TLSA
+	expires time.Time
+}
+
+// daneVerifier checks server certificates against TLSA records. DNSSEC
+// validation is delegated to resolver: only answers with AD bit are used.
+type daneVerifier struct {
+	mode     DANEMode
+	server   string
+	timeout  time.Duration
+	name     string
+	mux      sync.Mutex
+	cached   *tlsaResult
+	exchange func(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, error)
+}
+
+func newDANEVerifier(mode DANEMode, server string, timeout time.Duration, host string, port uint16) *daneVerifier {
+	if mode == DANEOff {
+		return nil
+	}
+	return &daneVerifier{
+		mode:     mode,
+		server:   server,
+		timeout:  timeout,
+		name:     dns.Fqdn("_" + strconv.Itoa(int(port)) + "._tcp." + host),
+		exchange: exchangeWithTCPFallback,
+	}
+}
+
+func exchangeWithTCPFallback(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, error) {
+	resp, _, err := new(dns.Client).ExchangeContext(ctx, m, server)
+	if err == nil && resp.Truncated {
+		resp, _, err = (&dns.Client{Net: "tcp"}).ExchangeContext(ctx, m, server)
+	}
+	return resp, err
+}
+
+func (dv *daneVerifier) lookup() ([]*dns.TLSA, error) {
+	dv.mux.Lock()
+	defer dv.mux.Unlock()
+	if dv.cached != nil && time.Now().Before(dv.cached.expires) {
+		return dv.cached.records, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dv.timeout)
+	defer cancel()
+	records, ttl, err := dv.query(ctx)
+	if err != nil {
+		// Failures aren't cached to not prolong resolver outage
+		return nil, err
+	}
+	if len(records) == 0 {
+		ttl = DANENegativeCacheTTL
+	}
+	if ttl > DANEMaxCacheTTL {
+		ttl = DANEMaxCacheTTL
+	}
+	dv.cached = &tlsaResult{
+		records: records,
+		expires: time.Now().Add(ttl),
+	}
+	return records, nil
+}
+
+func (dv *daneVerifier) query(ctx context.Context) ([]*dns.TLSA, time.Duration, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dv.name, dns.TypeTLSA)
+	m.SetEdns0(4096, true)
+	m.AuthenticatedData = true
+	resp, err := dv.exchange(ctx, m, dv.server)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w for %s: %w", errTLSALookup, dv.name, err)
+	}
+	switch resp.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+	default:
+		return nil, 0, fmt.Errorf("%w for %s: %s", errTLSALookup, dv.name, dns.RcodeToString[resp.Rcode])
+	}
+	// RFC 7672: TLSA records which are not DNSSEC-validated are unusable
+	if !resp.AuthenticatedData {
+		return nil, 0, nil
+	}
+	var (
+		records []*dns.TLSA
+		ttl     time.Duration
+	)
+	for _, rr := range resp.Answer {
+		tlsa, ok := rr.(*dns.TLSA)
+		if !ok {
+			continue
+		}
+		if tlsa.Usage != tlsaUsageDANETA && tlsa.Usage != tlsaUsageDANEEE {
+			continue
+		}
+		recordTTL := time.Duration(tlsa.Hdr.Ttl) * time.Second
+		if ttl == 0 || recordTTL < ttl {
+			ttl = recordTTL
+		}
+		records = append(records, tlsa)
+	}
+	return records, ttl, nil
+}
+
+func tlsaMatch(tlsa *dns.TLSA, cert *x509.Certificate) bool {
+	var data []byte
+	switch tlsa.Selector {
+	case 0:
+		data = cert.Raw
+	case 1:
+		data = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+	switch tlsa.MatchingType {
+	case 0:
+	case 1:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case 2:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	default:
+		return false
+	}
+	expected, err := hex.DecodeString(tlsa.Certificate)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(data, expected)
+}
+
+// verify checks certificates against TLSA records. verified reports if
+// secure TLSA records were found and matched. chainVerify is used to
+// validate leaf against trust anchor for DANE-TA records.
+func (dv *daneVerifier) verify(certs []*x509.Certificate,
+	chainVerify func([]*x509.Certificate, *x509.CertPool) ([][]*x509.Certificate, error)) (verified bool, err error) {
+	records, err := dv.lookup()
+	if err != nil {
+		return false, err
+	}
+	if len(records) == 0 {
+		if dv.mode == DANEOnly {
+			return false, fmt.Errorf("no DNSSEC-secured TLSA records for %s", dv.name)
+		}
+		return false, nil
+	}
+	for _, tlsa := range records {
+		switch tlsa.Usage {
+		case tlsaUsageDANEEE:
+			if tlsaMatch(tlsa, certs[0]) {
+				return true, nil
+			}
+		case tlsaUsageDANETA:
+			for _, cert := range certs[1:] {
+				if !tlsaMatch(tlsa, cert) {
+					continue
+				}
+				anchors := x509.NewCertPool()
+				anchors.AddCert(cert)
+				if _, err := chainVerify(certs, anchors); err == nil {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, fmt.Errorf("server certificate doesn't match any TLSA record for %s", dv.name)
+}
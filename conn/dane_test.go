@@ -0,0 +1,139 @@
+package conn
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startTestDNS runs authoritative server stand-in which answers TLSA
+// queries with given records and AD bit.
+func startTestDNS(t *testing.T, secure bool, records ...*dns.TLSA) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			resp.AuthenticatedData = secure
+			for _, rr := range records {
+				rr.Hdr = dns.RR_Header{
+					Name:   req.Question[0].Name,
+					Rrtype: dns.TypeTLSA,
+					Class:  dns.ClassINET,
+					Ttl:    300,
+				}
+				resp.Answer = append(resp.Answer, rr)
+			}
+			w.WriteMsg(resp)
+		}),
+	}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func tlsaSPKISHA256(usage uint8, cert *x509.Certificate) *dns.TLSA {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return &dns.TLSA{
+		Usage:        usage,
+		Selector:     1,
+		MatchingType: 1,
+		Certificate:  hex.EncodeToString(sum[:]),
+	}
+}
+
+func TestDANE(t *testing.T) {
+	pki := newTestPKI(t)
+	other := newTestPKI(t)
+	certs := []*x509.Certificate{pki.leaf, pki.ca}
+	v := &verifier{serverName: "server.example.com"}
+
+	for _, tc := range []struct {
+		name         string
+		mode         DANEMode
+		secure       bool
+		records      []*dns.TLSA
+		wantVerified bool
+		wantErr      bool
+	}{
+		{"EE match", DANEOnly, true, []*dns.TLSA{tlsaSPKISHA256(tlsaUsageDANEEE, pki.leaf)}, true, false},
+		{"TA match", DANEOnly, true, []*dns.TLSA{tlsaSPKISHA256(tlsaUsageDANETA, pki.ca)}, true, false},
+		{"EE mismatch", DANEOnly, true, []*dns.TLSA{tlsaSPKISHA256(tlsaUsageDANEEE, other.leaf)}, false, true},
+		{"TA mismatch", DANEWithCA, true, []*dns.TLSA{tlsaSPKISHA256(tlsaUsageDANETA, other.ca)}, false, true},
+		{"backup record", DANEOnly, true, []*dns.TLSA{
+			tlsaSPKISHA256(tlsaUsageDANEEE, other.leaf),
+			tlsaSPKISHA256(tlsaUsageDANEEE, pki.leaf),
+		}, true, false},
+		{"insecure only", DANEOnly, false, []*dns.TLSA{tlsaSPKISHA256(tlsaUsageDANEEE, pki.leaf)}, false, true},
+		{"insecure with CA", DANEWithCA, false, []*dns.TLSA{tlsaSPKISHA256(tlsaUsageDANEEE, other.leaf)}, false, false},
+		{"absent with CA", DANEWithCA, true, nil, false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := startTestDNS(t, tc.secure, tc.records...)
+			dv := newDANEVerifier(tc.mode, server, time.Second, "server.example.com", 443)
+			verified, err := dv.verify(certs, v.verifyChain)
+			if verified != tc.wantVerified || (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected result: verified=%t err=%v", verified, err)
+			}
+		})
+	}
+}
+
+func TestTLSAName(t *testing.T) {
+	dv := newDANEVerifier(DANEOnly, "", time.Second, "example.com", 8443)
+	if dv.name != "_8443._tcp.example.com." {
+		t.Fatalf("unexpected TLSA name %q", dv.name)
+	}
+}
+
+func TestDANELookupFailure(t *testing.T) {
+	pki := newTestPKI(t)
+	certs := []*x509.Certificate{pki.leaf, pki.ca}
+	for _, tc := range []struct {
+		name     string
+		exchange func(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, error)
+	}{
+		{"timeout", func(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, error) {
+			return nil, context.DeadlineExceeded
+		}},
+		{"SERVFAIL", func(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, error) {
+			resp := new(dns.Msg)
+			resp.SetRcode(m, dns.RcodeServerFailure)
+			return resp, nil
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			queries := 0
+			dv := newDANEVerifier(DANEOnly, "", time.Second, "server.example.com", 443)
+			dv.exchange = func(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, error) {
+				queries++
+				return tc.exchange(ctx, m, server)
+			}
+			v := &verifier{serverName: "server.example.com", dane: dv}
+			for i := 0; i < 2; i++ {
+				err := v.verifyPeer(certs, nil)
+				if err == nil {
+					t.Fatal("certificate accepted without TLSA records")
+				}
+				dialErr := newHandshakeError("server.example.com:443", err)
+				if dialErr.Permanent() {
+					t.Fatalf("lookup failure classified as configuration error: %v", dialErr)
+				}
+			}
+			if queries != 2 {
+				t.Fatalf("%d queries, failed lookup must not be cached", queries)
+			}
+		})
+	}
+}
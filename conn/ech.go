@@ -0,0 +1,97 @@
+package conn
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/miekg/dns"
+)
+
+// LoadECHConfigList reads ECHConfigList from file. File may contain PEM
+// block of ECHCONFIG type, base64-encoded list as published in DNS or
+// raw binary list.
+func LoadECHConfigList(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "ECHCONFIG" {
+			return nil, fmt.Errorf("unexpected PEM block %q in ECH config file", block.Type)
+		}
+		return block.Bytes, nil
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data))); err == nil {
+		return decoded, nil
+	}
+	return data, nil
+}
+
+// FetchECHConfigList looks up ECHConfigList in HTTPS DNS record of host.
+func FetchECHConfigList(ctx context.Context, server, host string) ([]byte, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(host), dns.TypeHTTPS)
+	m.SetEdns0(4096, false)
+	resp, err := exchangeWithTCPFallback(ctx, m, server)
+	if err != nil {
+		return nil, fmt.Errorf("HTTPS record lookup of %s failed: %w", host, err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("HTTPS record lookup of %s failed: %s", host, dns.RcodeToString[resp.Rcode])
+	}
+	for _, rr := range resp.Answer {
+		https, ok := rr.(*dns.HTTPS)
+		if !ok {
+			continue
+		}
+		for _, kv := range https.Value {
+			if ech, ok := kv.(*dns.SVCBECHConfig); ok && len(ech.ECH) > 0 {
+				return ech.ECH, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no ECH config in HTTPS record of %s", host)
+}
+
+// verifyECHRejection checks certificate presented for client-facing
+// server name when server rejects ECH. It is required to trust retry
+// configs provided by server.
+func (cf *TLSConnFactory) verifyECHRejection(cs tls.ConnectionState) error {
+	certs := cs.PeerCertificates
+	if len(certs) == 0 {
+		return errors.New("tls: server presented no certificates")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         cf.material.get().roots,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return &tls.CertificateVerificationError{
+			UnverifiedCertificates: certs,
+			Err:                    err,
+		}
+	}
+	return nil
+}
+
+// configForDial returns TLS config with current ECH configuration.
+func (cf *TLSConnFactory) configForDial() *tls.Config {
+	echConfig := cf.echConfig.Load()
+	if echConfig == nil {
+		return cf.tlsConfig
+	}
+	cfg := cf.tlsConfig.Clone()
+	cfg.EncryptedClientHelloConfigList = *echConfig
+	return cfg
+}
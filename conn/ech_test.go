@@ -0,0 +1,34 @@
+package conn
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadECHConfigList(t *testing.T) {
+	list := []byte{0x00, 0x04, 0xfe, 0x0d, 0x00, 0x00}
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"pem":    pem.EncodeToMemory(&pem.Block{Type: "ECHCONFIG", Bytes: list}),
+		"base64": []byte(base64.StdEncoding.EncodeToString(list) + "\n"),
+		"raw":    list,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			res, err := LoadECHConfigList(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(res, list) {
+				t.Fatalf("unexpected ECH config list %x", res)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+)
+
+type DialStage int
+
+const (
+	StageResolve DialStage = iota
+	StageConnect
+	StageProxy
+	StageStartTLS
+	StageHandshake
+	StagePreamble
+)
+
+func (s DialStage) String() string {
+	switch s {
+	case StageResolve:
+		return "DNS resolution"
+	case StageConnect:
+		return "TCP connect"
+	case StageProxy:
+		return "proxy CONNECT"
+	case StageStartTLS:
+		return "STARTTLS negotiation"
+	case StageHandshake:
+		return "TLS handshake"
+	case StagePreamble:
+		return "preamble"
+	default:
+		return fmt.Sprintf("stage %d", int(s))
+	}
+}
+
+// DialError is returned by connection factories and identifies stage
+// where connection attempt failed.
+type DialError struct {
+	Stage DialStage
+	Addr  string
+	Err   error
+	// Config is set for certificate and TLS configuration errors which
+	// are not going to go away on their own.
+	Config bool
+}
+
+func (e *DialError) Error() string {
+	if e.Config {
+		return fmt.Sprintf("%s for %s rejected: %v", e.Stage, e.Addr, e.Err)
+	}
+	if e.Timeout() {
+		return fmt.Sprintf("%s for %s timed out: %v", e.Stage, e.Addr, e.Err)
+	}
+	return fmt.Sprintf("%s for %s failed: %v", e.Stage, e.Addr, e.Err)
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DialError) Timeout() bool {
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(e.Err, &netErr) && netErr.Timeout()
+}
+
+// Permanent reports whether retry is unlikely to succeed without
+// configuration change on either side.
+func (e *DialError) Permanent() bool {
+	return e.Config
+}
+
+func newConnectError(addr string, err error) *DialError {
+	stage := StageConnect
+	config := false
+	var (
+		dnsErr   *net.DNSError
+		proxyErr *ProxyError
+	)
+	switch {
+	case errors.As(err, &dnsErr):
+		stage = StageResolve
+	case errors.As(err, &proxyErr):
+		stage = StageProxy
+		config = proxyErr.Permanent()
+	}
+	return &DialError{
+		Stage:  stage,
+		Addr:   addr,
+		Err:    err,
+		Config: config,
+	}
+}
+
+func newStartTLSError(addr string, err error) *DialError {
+	return &DialError{
+		Stage:  StageStartTLS,
+		Addr:   addr,
+		Err:    err,
+		Config: errors.Is(err, errStartTLSRefused),
+	}
+}
+
+func newHandshakeError(addr string, err error) *DialError {
+	return &DialError{
+		Stage:  StageHandshake,
+		Addr:   addr,
+		Err:    err,
+		Config: isConfigError(err),
+	}
+}
+
+// Remote alerts are reported as net.OpError wrapping unexported alert type,
+// so they can be recognized only by text.
+var configAlerts = map[string]bool{
+	"tls: handshake failure":               true,
+	"tls: bad certificate":                 true,
+	"tls: unsupported certificate":         true,
+	"tls: revoked certificate":             true,
+	"tls: expired certificate":             true,
+	"tls: unknown certificate":             true,
+	"tls: unknown certificate authority":   true,
+	"tls: access denied":                   true,
+	"tls: protocol version not supported":  true,
+	"tls: insufficient security level":     true,
+	"tls: certificate required":            true,
+	"tls: no application protocol":         true,
+	"tls: unrecognized name":               true,
+	"tls: bad certificate status response": true,
+	"tls: encrypted client hello required": true,
+}
+
+func isConfigError(err error) bool {
+	var (
+		verifyErr    *tls.CertificateVerificationError
+		echErr       *tls.ECHRejectionError
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+		hostnameErr  x509.HostnameError
+		opErr        *net.OpError
+	)
+	switch {
+	case errors.As(err, &verifyErr),
+		errors.As(err, &echErr),
+		errors.As(err, &recordErr),
+		errors.As(err, &authorityErr),
+		errors.As(err, &invalidErr),
+		errors.As(err, &hostnameErr):
+		return true
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		return configAlerts[opErr.Err.Error()]
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestDialErrorClassification(t *testing.T) {
+	const addr = "server.example.com:443"
+	remoteAlert := func(alert string) error {
+		return &net.OpError{Op: "remote error", Err: errors.New(alert)}
+	}
+	for _, tc := range []struct {
+		name          string
+		err           *DialError
+		wantStage     DialStage
+		wantPermanent bool
+		wantTimeout   bool
+	}{
+		{"DNS failure", newConnectError(addr, fmt.Errorf("dial: %w", &net.DNSError{Err: "no such host", IsNotFound: true})),
+			StageResolve, false, false},
+		{"connection refused", newConnectError(addr, &net.OpError{Op: "dial", Err: errors.New("connection refused")}),
+			StageConnect, false, false},
+		{"connect timeout", newConnectError(addr, context.DeadlineExceeded), StageConnect, false, true},
+		{"proxy auth required", newConnectError(addr, &ProxyError{StatusCode: http.StatusProxyAuthRequired}),
+			StageProxy, true, false},
+		{"proxy bad gateway", newConnectError(addr, &ProxyError{StatusCode: http.StatusBadGateway}),
+			StageProxy, false, false},
+		{"STARTTLS refused", newStartTLSError(addr, refused("server said %q", "454")),
+			StageStartTLS, true, false},
+		{"STARTTLS connection reset", newStartTLSError(addr, io.ErrUnexpectedEOF), StageStartTLS, false, false},
+		{"certificate verification", newHandshakeError(addr, &tls.CertificateVerificationError{Err: errors.New("pin mismatch")}),
+			StageHandshake, true, false},
+		{"unknown authority", newHandshakeError(addr, x509.UnknownAuthorityError{}), StageHandshake, true, false},
+		{"hostname mismatch", newHandshakeError(addr, x509.HostnameError{Host: "other.example.com"}),
+			StageHandshake, true, false},
+		{"expired certificate", newHandshakeError(addr, x509.CertificateInvalidError{Reason: x509.Expired}),
+			StageHandshake, true, false},
+		{"not TLS server", newHandshakeError(addr, tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}),
+			StageHandshake, true, false},
+		{"client certificate rejected", newHandshakeError(addr, remoteAlert("tls: bad certificate")),
+			StageHandshake, true, false},
+		{"protocol version rejected", newHandshakeError(addr, remoteAlert("tls: protocol version not supported")),
+			StageHandshake, true, false},
+		{"server internal error", newHandshakeError(addr, remoteAlert("tls: internal error")),
+			StageHandshake, false, false},
+		{"connection reset", newHandshakeError(addr, io.EOF), StageHandshake, false, false},
+		{"handshake timeout", newHandshakeError(addr, context.DeadlineExceeded), StageHandshake, false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.err.Stage != tc.wantStage {
+				t.Errorf("stage %v, expected %v", tc.err.Stage, tc.wantStage)
+			}
+			if tc.err.Permanent() != tc.wantPermanent {
+				t.Errorf("permanent %v, expected %v", tc.err.Permanent(), tc.wantPermanent)
+			}
+			if tc.err.Timeout() != tc.wantTimeout {
+				t.Errorf("timeout %v, expected %v", tc.err.Timeout(), tc.wantTimeout)
+			}
+			var permErr interface{ Permanent() bool }
+			if !errors.As(fmt.Errorf("wrapped: %w", tc.err), &permErr) || permErr.Permanent() != tc.wantPermanent {
+				t.Errorf("classification lost after wrapping")
+			}
+		})
+	}
+}
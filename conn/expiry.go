@@ -0,0 +1,103 @@
+package conn
+
+import (
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	clog "github.com/Snawoot/steady-tun/log"
+)
+
+var DefaultExpiryThresholds = []time.Duration{30 * 24 * time.Hour, 7 * 24 * time.Hour, 24 * time.Hour}
+
+// ParseDurations parses comma-separated list of durations.
+func ParseDurations(s string) ([]time.Duration, error) {
+	var res []time.Duration
+	for _, item := range splitList(s) {
+		d, err := time.ParseDuration(item)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
+func FormatDurations(ds []time.Duration) string {
+	var parts []string
+	for _, d := range ds {
+		parts = append(parts, d.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// expiryMonitor logs warning once per certificate for each threshold it
+// crosses and remembers expiration time of last checked certificate.
+type expiryMonitor struct {
+	kind       string
+	thresholds []time.Duration
+	logger     *clog.CondLogger
+	mux        sync.Mutex
+	warned     map[string]int
+	notAfter   atomic.Int64
+}
+
+func newExpiryMonitor(kind string, thresholds []time.Duration, logger *clog.CondLogger) *expiryMonitor {
+	thresholds = slices.Clone(thresholds)
+	slices.Sort(thresholds)
+	slices.Reverse(thresholds)
+	return &expiryMonitor{
+		kind:       kind,
+		thresholds: thresholds,
+		logger:     logger,
+		warned:     make(map[string]int),
+	}
+}
+
+func (m *expiryMonitor) check(cert *x509.Certificate) {
+	if cert == nil {
+		return
+	}
+	m.notAfter.Store(cert.NotAfter.Unix())
+	remaining := time.Until(cert.NotAfter)
+	level := 0
+	for _, t := range m.thresholds {
+		if remaining <= t {
+			level++
+		}
+	}
+	if remaining <= 0 {
+		level = len(m.thresholds) + 1
+	}
+	if level == 0 {
+		return
+	}
+
+	key := CertFingerprint(cert)
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.warned[key] >= level {
+		return
+	}
+	m.warned[key] = level
+	desc := fmt.Sprintf("%s certificate (subject: %q, serial: %s)", m.kind, cert.Subject, cert.SerialNumber)
+	if remaining <= 0 {
+		m.logger.Critical("%s has expired at %v!", desc, cert.NotAfter)
+	} else {
+		m.logger.Warning("%s expires in %v at %v.", desc, remaining.Truncate(time.Minute), cert.NotAfter)
+	}
+}
+
+// expiry returns expiration time of last checked certificate or zero
+// time if there were no checks yet.
+func (m *expiryMonitor) expiry() time.Time {
+	ts := m.notAfter.Load()
+	if ts == 0 {
+		return time.Time{}
+	}
+	return time.Unix(ts, 0)
+}
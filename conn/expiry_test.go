@@ -0,0 +1,84 @@
+package conn
+
+import (
+	"bytes"
+	"crypto/x509"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	clog "github.com/Snawoot/steady-tun/log"
+)
+
+func TestExpiryMonitor(t *testing.T) {
+	var buf bytes.Buffer
+	m := newExpiryMonitor("Client", []time.Duration{24 * time.Hour, 30 * 24 * time.Hour, 7 * 24 * time.Hour},
+		clog.NewCondLogger(log.New(&buf, "", 0), clog.DEBUG))
+	if !m.expiry().IsZero() {
+		t.Fatal("expiry known before first check")
+	}
+	day := 24 * time.Hour
+
+	// Same certificate checked with decreasing remaining validity
+	// simulates passage of time.
+	for _, tc := range []struct {
+		name      string
+		raw       string
+		remaining time.Duration
+		want      string
+	}{
+		{"far from expiry", "a", 60 * day, ""},
+		{"first threshold", "a", 20 * day, "WARNING"},
+		{"first threshold repeated", "a", 19 * day, ""},
+		{"second threshold", "a", 5 * day, "WARNING"},
+		{"last threshold", "a", 12 * time.Hour, "WARNING"},
+		{"last threshold repeated", "a", 11 * time.Hour, ""},
+		{"expired", "a", -time.Hour, "CRITICAL"},
+		{"expired repeated", "a", -2 * time.Hour, ""},
+		{"other certificate", "b", 5 * day, "WARNING"},
+		{"renewed certificate", "c", 90 * day, ""},
+		{"skipped thresholds", "d", 2 * time.Hour, "WARNING"},
+		{"skipped thresholds repeated", "d", time.Hour, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf.Reset()
+			notAfter := time.Now().Add(tc.remaining).Truncate(time.Second)
+			m.check(&x509.Certificate{Raw: []byte(tc.raw), NotAfter: notAfter})
+			logged := strings.TrimSpace(buf.String())
+			if tc.want == "" && logged != "" {
+				t.Fatalf("unexpected log %q", logged)
+			}
+			if tc.want != "" && (!strings.Contains(logged, tc.want) || strings.Count(logged, "\n") > 0) {
+				t.Fatalf("expected single %s log, got %q", tc.want, logged)
+			}
+			if !m.expiry().Equal(notAfter) {
+				t.Fatalf("expiry %v, expected %v", m.expiry(), notAfter)
+			}
+		})
+	}
+}
+
+func TestParseDurations(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    []time.Duration
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"720h, 168h,24h", []time.Duration{720 * time.Hour, 168 * time.Hour, 24 * time.Hour}, false},
+		{"1d", nil, true},
+	} {
+		got, err := ParseDurations(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("ParseDurations(%q): unexpected error: %v", tc.in, err)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Fatalf("ParseDurations(%q) = %v, expected %v", tc.in, got, tc.want)
+		}
+	}
+	if s := FormatDurations(DefaultExpiryThresholds); s != "720h0m0s,168h0m0s,24h0m0s" {
+		t.Fatalf("unexpected default thresholds %q", s)
+	}
+}
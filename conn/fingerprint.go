@@ -0,0 +1,195 @@
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// Fingerprint selects ClientHello profile presented to server.
+type Fingerprint int
+
+const (
+	// FingerprintGo uses crypto/tls handshake
+	FingerprintGo Fingerprint = iota
+	FingerprintChrome
+	FingerprintFirefox
+	FingerprintSafari
+	FingerprintEdge
+	FingerprintIOS
+	FingerprintRandomized
+)
+
+var fingerprintNames = map[string]Fingerprint{
+	"go":         FingerprintGo,
+	"chrome":     FingerprintChrome,
+	"firefox":    FingerprintFirefox,
+	"safari":     FingerprintSafari,
+	"edge":       FingerprintEdge,
+	"ios":        FingerprintIOS,
+	"randomized": FingerprintRandomized,
+}
+
+func ParseFingerprint(s string) (Fingerprint, error) {
+	if s == "" {
+		return FingerprintGo, nil
+	}
+	fp, ok := fingerprintNames[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown ClientHello fingerprint %q", s)
+	}
+	return fp, nil
+}
+
+func (fp Fingerprint) helloID() utls.ClientHelloID {
+	switch fp {
+	case FingerprintChrome:
+		return utls.HelloChrome_Auto
+	case FingerprintFirefox:
+		return utls.HelloFirefox_Auto
+	case FingerprintSafari:
+		return utls.HelloSafari_Auto
+	case FingerprintEdge:
+		return utls.HelloEdge_Auto
+	case FingerprintIOS:
+		return utls.HelloIOS_Auto
+	default:
+		return utls.HelloRandomizedALPN
+	}
+}
+
+// newUTLSConfig mirrors crypto/tls configuration for uTLS client. Server
+// certificate is always checked by verifier.
+func newUTLSConfig(cfg *tls.Config, material *materialLoader, v *verifier) *utls.Config {
+	return &utls.Config{
+		ServerName: cfg.ServerName,
+		GetClientCertificate: func(_ *utls.CertificateRequestInfo) (*utls.Certificate, error) {
+			cert := material.get().cert
+			if cert == nil {
+				return new(utls.Certificate), nil
+			}
+			return &utls.Certificate{
+				Certificate: cert.Certificate,
+				PrivateKey:  cert.PrivateKey,
+				Leaf:        cert.Leaf,
+			}, nil
+		},
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs utls.ConnectionState) error {
+			return v.verifyPeer(cs.PeerCertificates, cs.OCSPResponse)
+		},
+		MinVersion:   cfg.MinVersion,
+		MaxVersion:   cfg.MaxVersion,
+		NextProtos:   cfg.NextProtos,
+		KeyLogWriter: cfg.KeyLogWriter,
+	}
+}
+
+// setALPN replaces browser ALPN protocols. Without protocols ALPN and
+// dependent ALPS extensions are removed: server must not switch tunneled
+// connection to h2.
+func setALPN(spec *utls.ClientHelloSpec, protos []string) {
+	exts := spec.Extensions[:0]
+	for _, ext := range spec.Extensions {
+		switch e := ext.(type) {
+		case *utls.ALPNExtension:
+			if len(protos) == 0 {
+				continue
+			}
+			e.AlpnProtocols = protos
+		case *utls.ApplicationSettingsExtension:
+			if len(protos) == 0 {
+				continue
+			}
+		}
+		exts = append(exts, ext)
+	}
+	spec.Extensions = exts
+}
+
+// setVersions limits TLS versions offered by browser profile to configured
+// range. Zero bounds keep profile defaults.
+func setVersions(spec *utls.ClientHelloSpec, minVersion, maxVersion uint16) error {
+	inRange := func(v uint16) bool {
+		return (minVersion == 0 || v >= minVersion) && (maxVersion == 0 || v <= maxVersion)
+	}
+	if spec.TLSVersMin != 0 && minVersion > spec.TLSVersMin {
+		spec.TLSVersMin = minVersion
+	}
+	if spec.TLSVersMax != 0 && maxVersion != 0 && maxVersion < spec.TLSVersMax {
+		spec.TLSVersMax = maxVersion
+	}
+	if spec.TLSVersMin > spec.TLSVersMax {
+		return errors.New("ClientHello fingerprint doesn't support configured TLS versions")
+	}
+	for _, ext := range spec.Extensions {
+		sv, ok := ext.(*utls.SupportedVersionsExtension)
+		if !ok {
+			continue
+		}
+		var versions []uint16
+		found := false
+		for _, v := range sv.Versions {
+			if v&0x0f0f == 0x0a0a {
+				// GREASE value
+				versions = append(versions, v)
+			} else if inRange(v) {
+				versions = append(versions, v)
+				found = true
+			}
+		}
+		if !found {
+			return errors.New("ClientHello fingerprint doesn't support configured TLS versions")
+		}
+		sv.Versions = versions
+	}
+	return nil
+}
+
+// fingerprintSpec returns ClientHello of browser profile adjusted to
+// configured ALPN protocols and TLS versions.
+func fingerprintSpec(fp Fingerprint, cfg *utls.Config) (utls.ClientHelloSpec, error) {
+	spec, err := utls.UTLSIdToSpec(fp.helloID())
+	if err != nil {
+		return spec, err
+	}
+	setALPN(&spec, cfg.NextProtos)
+	if err := setVersions(&spec, cfg.MinVersion, cfg.MaxVersion); err != nil {
+		return spec, err
+	}
+	return spec, nil
+}
+
+// utlsHandshake performs handshake with browser-like ClientHello. It
+// returns connection state converted to crypto/tls type.
+func (cf *TLSConnFactory) utlsHandshake(ctx context.Context, netConn net.Conn) (net.Conn, tls.ConnectionState, error) {
+	spec, err := fingerprintSpec(cf.fingerprint, cf.utlsConfig)
+	if err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	// Applied preset overwrites versions in config
+	uconn := utls.UClient(netConn, cf.utlsConfig.Clone(), utls.HelloCustom)
+	if err := uconn.ApplyPreset(&spec); err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	if err := uconn.HandshakeContext(ctx); err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	ucs := uconn.ConnectionState()
+	return uconn, tls.ConnectionState{
+		Version:            ucs.Version,
+		HandshakeComplete:  ucs.HandshakeComplete,
+		DidResume:          ucs.DidResume,
+		CipherSuite:        ucs.CipherSuite,
+		NegotiatedProtocol: ucs.NegotiatedProtocol,
+		ServerName:         ucs.ServerName,
+		PeerCertificates:   ucs.PeerCertificates,
+		VerifiedChains:     ucs.VerifiedChains,
+		OCSPResponse:       ucs.OCSPResponse,
+	}, nil
+}
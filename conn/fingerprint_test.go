@@ -0,0 +1,144 @@
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCAFile(t *testing.T, pki *testPKI) string {
+	t.Helper()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pki.ca.Raw})
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return caFile
+}
+
+// startTLSServer runs TLS server which reports state of each handshake.
+func startTLSServer(t *testing.T, pki *testPKI, nextProtos []string) (uint16, <-chan tls.ConnectionState) {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{pki.leaf.Raw},
+			PrivateKey:  pki.leafKey,
+		}},
+		NextProtos: nextProtos,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	states := make(chan tls.ConnectionState, 10)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			tlsConn := c.(*tls.Conn)
+			if tlsConn.Handshake() == nil {
+				states <- tlsConn.ConnectionState()
+			}
+			c.Close()
+		}
+	}()
+	return uint16(ln.Addr().(*net.TCPAddr).Port), states
+}
+
+func TestFingerprintALPN(t *testing.T) {
+	pki := newTestPKI(t)
+	caFile := writeCAFile(t, pki)
+	port, states := startTLSServer(t, pki, []string{"h2", "http/1.1"})
+	for _, fp := range []Fingerprint{FingerprintChrome, FingerprintFirefox, FingerprintSafari} {
+		for _, tc := range []struct {
+			nextProtos []string
+			want       string
+		}{
+			{nil, ""},
+			{[]string{"http/1.1"}, "http/1.1"},
+		} {
+			cf, err := NewTLSConnFactory("127.0.0.1", port, (&net.Dialer{}).DialContext, TLSOptions{
+				CAFiles:          []string{caFile},
+				HostnameCheck:    true,
+				ServerName:       "server.example.com",
+				Dialers:          1,
+				HandshakeTimeout: 5 * time.Second,
+				NextProtos:       tc.nextProtos,
+				Fingerprint:      fp,
+			}, testLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := cf.DialContext(context.Background())
+			if err != nil {
+				t.Fatalf("fingerprint %d: %v", fp, err)
+			}
+			c.Close()
+			if cs := <-states; cs.NegotiatedProtocol != tc.want {
+				t.Errorf("fingerprint %d with ALPN %q: negotiated %q", fp, tc.nextProtos, cs.NegotiatedProtocol)
+			}
+		}
+	}
+}
+
+func TestFingerprintVersions(t *testing.T) {
+	pki := newTestPKI(t)
+	caFile := writeCAFile(t, pki)
+	port, states := startTLSServer(t, pki, nil)
+	const dials = 8
+	for _, tc := range []struct {
+		name                   string
+		minVersion, maxVersion uint16
+		want                   uint16
+	}{
+		{"default", 0, 0, tls.VersionTLS13},
+		{"TLS 1.3 only", tls.VersionTLS13, 0, tls.VersionTLS13},
+		{"up to TLS 1.2", 0, tls.VersionTLS12, tls.VersionTLS12},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cf, err := NewTLSConnFactory("127.0.0.1", port, (&net.Dialer{}).DialContext, TLSOptions{
+				CAFiles:          []string{caFile},
+				HostnameCheck:    true,
+				ServerName:       "server.example.com",
+				Dialers:          dials,
+				HandshakeTimeout: 5 * time.Second,
+				MinVersion:       tc.minVersion,
+				MaxVersion:       tc.maxVersion,
+				Fingerprint:      FingerprintSafari,
+			}, testLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Dial concurrently: profile must not leak into shared config
+			errs := make(chan error, dials)
+			for i := 0; i < dials; i++ {
+				go func() {
+					c, err := cf.DialContext(context.Background())
+					if err == nil {
+						c.Close()
+					}
+					errs <- err
+				}()
+			}
+			for i := 0; i < dials; i++ {
+				if err := <-errs; err != nil {
+					t.Fatal(err)
+				}
+				if cs := <-states; cs.Version != tc.want {
+					t.Errorf("negotiated version %#x, expected %#x", cs.Version, tc.want)
+				}
+			}
+			if cf.utlsConfig.MinVersion != tc.minVersion || cf.utlsConfig.MaxVersion != tc.maxVersion {
+				t.Fatalf("shared config versions changed to %#x-%#x",
+					cf.utlsConfig.MinVersion, cf.utlsConfig.MaxVersion)
+			}
+		})
+	}
+}
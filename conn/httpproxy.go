@@ -0,0 +1,146 @@
+package conn
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ProxyError reports non-200 response of HTTP proxy to CONNECT request.
+type ProxyError struct {
+	Proxy      string
+	StatusCode int
+	Status     string
+}
+
+func (e *ProxyError) Error() string {
+	return fmt.Sprintf("proxy %s responded %q", e.Proxy, e.Status)
+}
+
+// Permanent reports whether proxy refuses connection due to
+// authentication or policy.
+func (e *ProxyError) Permanent() bool {
+	switch e.StatusCode {
+	case http.StatusProxyAuthRequired, http.StatusForbidden, http.StatusMethodNotAllowed:
+		return true
+	default:
+		return false
+	}
+}
+
+type HTTPProxyOptions struct {
+	// Addr is host:port of proxy
+	Addr string
+	// TLSConfig enables TLS connection to proxy itself
+	TLSConfig *tls.Config
+	// Username enables Basic authentication
+	Username, Password string
+	// Header is added to CONNECT request, e.g. for custom
+	// authentication
+	Header http.Header
+	// Timeout limits whole connection setup if non-zero
+	Timeout time.Duration
+}
+
+// bufferedConn returns data read ahead along with proxy response.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// NewHTTPProxyDialer returns dialer which establishes connections using
+// HTTP/1.1 CONNECT method. Destination name is resolved by proxy unless
+// dialer is wrapped with local resolver.
+func NewHTTPProxyDialer(opts HTTPProxyOptions, forward ContextDialer) ContextDialer {
+	header := opts.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if opts.Username != "" {
+		creds := base64.StdEncoding.EncodeToString([]byte(opts.Username + ":" + opts.Password))
+		header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	var tlsConfig *tls.Config
+	if opts.TLSConfig != nil {
+		tlsConfig = opts.TLSConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			host, _, _ := net.SplitHostPort(opts.Addr)
+			tlsConfig.ServerName = host
+		}
+	}
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := forward(ctx, "tcp", opts.Addr)
+		if err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		stop := context.AfterFunc(ctx, func() {
+			conn.SetDeadline(time.Unix(1, 0))
+		})
+		res, err := connect(conn, tlsConfig, opts.Addr, address, header)
+		if !stop() && err == nil {
+			err = ctx.Err()
+		}
+		if err == nil {
+			err = conn.SetDeadline(time.Time{})
+		}
+		if err != nil {
+			conn.Close()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		return res, nil
+	}
+	return withTimeout(dial, opts.Timeout)
+}
+
+func connect(conn net.Conn, tlsConfig *tls.Config, proxyAddr, address string, header http.Header) (net.Conn, error) {
+	if tlsConfig != nil {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, fmt.Errorf("TLS handshake with proxy %s failed: %w", proxyAddr, err)
+		}
+		conn = tlsConn
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: header,
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, fmt.Errorf("bad response from proxy %s: %w", proxyAddr, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &ProxyError{
+			Proxy:      proxyAddr,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+	}
+	// Server may speak first, e.g. SMTP greeting
+	if r.Buffered() > 0 {
+		return &bufferedConn{conn, r}, nil
+	}
+	return conn, nil
+}
@@ -0,0 +1,118 @@
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// proxyStandIn accepts CONNECT requests with expected credentials and
+// token header. It greets client through the tunnel right away to check
+// that data read ahead with response is not lost.
+func proxyStandIn(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// BasicAuth parses only Authorization header
+		r.Header.Set("Authorization", r.Header.Get("Proxy-Authorization"))
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "user" || password != "secret" || r.Header.Get("X-Token") != "t0ken" {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		c, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		rw.WriteString("HTTP/1.1 200 Connection established\r\n\r\nhello")
+		rw.Flush()
+		go io.Copy(upstream, rw)
+		io.Copy(c, upstream)
+	})
+}
+
+func TestHTTPProxyDialer(t *testing.T) {
+	echoAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(startEchoServer(t)))
+	plain := httptest.NewServer(proxyStandIn(t))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(proxyStandIn(t))
+	defer secure.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(secure.Certificate())
+	direct := (&net.Dialer{}).DialContext
+	header := http.Header{"X-Token": {"t0ken"}}
+
+	for _, tc := range []struct {
+		name string
+		opts HTTPProxyOptions
+	}{
+		{"plain", HTTPProxyOptions{Addr: plain.Listener.Addr().String()}},
+		{"tls", HTTPProxyOptions{Addr: secure.Listener.Addr().String(), TLSConfig: &tls.Config{RootCAs: roots}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := tc.opts
+			opts.Username, opts.Password, opts.Header, opts.Timeout = "user", "secret", header, time.Second
+			c, err := NewHTTPProxyDialer(opts, direct)(context.Background(), "tcp", echoAddr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			io.WriteString(c, "ping")
+			buf := make([]byte, 9)
+			if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "helloping" {
+				t.Fatalf("unexpected data %q: %v", buf, err)
+			}
+		})
+	}
+
+	t.Run("auth failure", func(t *testing.T) {
+		dialer := NewHTTPProxyDialer(HTTPProxyOptions{
+			Addr:     plain.Listener.Addr().String(),
+			Username: "user",
+			Password: "wrong",
+			Header:   header,
+		}, direct)
+		_, err := dialer(context.Background(), "tcp", echoAddr)
+		var proxyErr *ProxyError
+		if !errors.As(err, &proxyErr) || proxyErr.StatusCode != http.StatusProxyAuthRequired {
+			t.Fatalf("expected proxy error, got %v", err)
+		}
+		dialErr := newConnectError(echoAddr, err)
+		if dialErr.Stage != StageProxy || !dialErr.Permanent() {
+			t.Fatalf("unexpected classification of %v", dialErr)
+		}
+	})
+
+	t.Run("bad gateway", func(t *testing.T) {
+		dialer := NewHTTPProxyDialer(HTTPProxyOptions{
+			Addr:     plain.Listener.Addr().String(),
+			Username: "user",
+			Password: "secret",
+			Header:   header,
+		}, direct)
+		_, err := dialer(context.Background(), "tcp", "127.0.0.1:1")
+		var proxyErr *ProxyError
+		if !errors.As(err, &proxyErr) || proxyErr.StatusCode != http.StatusBadGateway || proxyErr.Permanent() {
+			t.Fatalf("expected transient proxy error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package conn
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ServerIdentity lists accepted server certificate subject alternative
+// names. Certificate is accepted if it matches any of them.
+type ServerIdentity struct {
+	DNSNames []string
+	IPs      []net.IP
+	URIs     []*url.URL
+	Emails   []string
+}
+
+func (id *ServerIdentity) Empty() bool {
+	return id == nil || len(id.DNSNames) == 0 && len(id.IPs) == 0 && len(id.URIs) == 0 && len(id.Emails) == 0
+}
+
+// ParseServerIdentity parses DNS names, IP addresses, URIs, for example
+// SPIFFE IDs, and email addresses into ServerIdentity.
+func ParseServerIdentity(dnsNames, ips, uris, emails []string) (*ServerIdentity, error) {
+	id := &ServerIdentity{
+		DNSNames: dnsNames,
+	}
+	for _, s := range ips {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("bad IP address %q", s)
+		}
+		id.IPs = append(id.IPs, ip)
+	}
+	for _, s := range uris {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad URI %q: %w", s, err)
+		}
+		if u.Scheme == "" {
+			return nil, fmt.Errorf("bad URI %q: scheme is missing", s)
+		}
+		id.URIs = append(id.URIs, u)
+	}
+	for _, s := range emails {
+		if _, _, ok := strings.Cut(s, "@"); !ok {
+			return nil, fmt.Errorf("bad email address %q", s)
+		}
+		id.Emails = append(id.Emails, s)
+	}
+	return id, nil
+}
+
+// emailEqual compares email addresses. Only domain part is case
+// insensitive.
+func emailEqual(a, b string) bool {
+	aLocal, aDomain, _ := strings.Cut(a, "@")
+	bLocal, bDomain, _ := strings.Cut(b, "@")
+	return aLocal == bLocal && strings.EqualFold(aDomain, bDomain)
+}
+
+func (id *ServerIdentity) match(cert *x509.Certificate) bool {
+	for _, name := range id.DNSNames {
+		// VerifyHostname handles wildcard certificates. IP addresses
+		// are matched below.
+		if net.ParseIP(name) == nil && cert.VerifyHostname(name) == nil {
+			return true
+		}
+	}
+	for _, ip := range id.IPs {
+		for _, certIP := range cert.IPAddresses {
+			if ip.Equal(certIP) {
+				return true
+			}
+		}
+	}
+	for _, u := range id.URIs {
+		for _, certURI := range cert.URIs {
+			if u.String() == certURI.String() {
+				return true
+			}
+		}
+	}
+	for _, email := range id.Emails {
+		for _, certEmail := range cert.EmailAddresses {
+			if emailEqual(email, certEmail) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (id *ServerIdentity) verify(cert *x509.Certificate) error {
+	if id.match(cert) {
+		return nil
+	}
+	var presented, expected []string
+	for _, name := range cert.DNSNames {
+		presented = append(presented, "DNS:"+name)
+	}
+	for _, ip := range cert.IPAddresses {
+		presented = append(presented, "IP:"+ip.String())
+	}
+	for _, u := range cert.URIs {
+		presented = append(presented, "URI:"+u.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		presented = append(presented, "email:"+email)
+	}
+	for _, name := range id.DNSNames {
+		expected = append(expected, "DNS:"+name)
+	}
+	for _, ip := range id.IPs {
+		expected = append(expected, "IP:"+ip.String())
+	}
+	for _, u := range id.URIs {
+		expected = append(expected, "URI:"+u.String())
+	}
+	for _, email := range id.Emails {
+		expected = append(expected, "email:"+email)
+	}
+	return fmt.Errorf("server certificate identity mismatch: presented [%s], expected one of [%s]",
+		strings.Join(presented, ", "), strings.Join(expected, ", "))
+}
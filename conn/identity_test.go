@@ -0,0 +1,75 @@
+package conn
+
+import (
+	"crypto/x509"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestServerIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://prod/ns/db/sa/proxy")
+	cert := &x509.Certificate{
+		DNSNames:       []string{"db.example.com", "*.db.example.org"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1")},
+		URIs:           []*url.URL{spiffe},
+		EmailAddresses: []string{"proxy@example.com"},
+	}
+
+	for _, tc := range []struct {
+		name                      string
+		dnsNames, ips, uris, mail []string
+		wantErr                   bool
+	}{
+		{"DNS match", []string{"db.example.com"}, nil, nil, nil, false},
+		{"DNS mismatch", []string{"web.example.com"}, nil, nil, nil, true},
+		{"DNS case insensitive", []string{"DB.Example.COM"}, nil, nil, nil, false},
+		{"wildcard DNS match", []string{"replica.db.example.org"}, nil, nil, nil, false},
+		{"wildcard DNS apex", []string{"db.example.org"}, nil, nil, nil, true},
+		{"wildcard DNS two labels", []string{"a.replica.db.example.org"}, nil, nil, nil, true},
+		{"DNS given IP", []string{"10.0.0.1"}, nil, nil, nil, true},
+		{"IP match", nil, []string{"10.0.0.1"}, nil, nil, false},
+		{"IPv6 match", nil, []string{"2001:db8:0::1"}, nil, nil, false},
+		{"IP mismatch", nil, []string{"10.0.0.2"}, nil, nil, true},
+		{"URI match", nil, nil, []string{"spiffe://prod/ns/db/sa/proxy"}, nil, false},
+		{"URI mismatch", nil, nil, []string{"spiffe://prod/ns/db/sa/other"}, nil, true},
+		{"email match", nil, nil, nil, []string{"proxy@example.com"}, false},
+		{"email domain case insensitive", nil, nil, nil, []string{"proxy@EXAMPLE.com"}, false},
+		{"email local part case sensitive", nil, nil, nil, []string{"Proxy@example.com"}, true},
+		{"email mismatch", nil, nil, nil, []string{"admin@example.com"}, true},
+		{"any of list", []string{"web.example.com"}, []string{"10.0.0.2"}, nil, []string{"proxy@example.com"}, false},
+		{"none of list", []string{"web.example.com"}, []string{"10.0.0.2"},
+			[]string{"spiffe://prod/ns/web/sa/proxy"}, []string{"admin@example.com"}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			id, err := ParseServerIdentity(tc.dnsNames, tc.ips, tc.uris, tc.mail)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = id.verify(cert)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseServerIdentity(t *testing.T) {
+	for _, tc := range []struct {
+		name                      string
+		dnsNames, ips, uris, mail []string
+		wantErr                   bool
+	}{
+		{"empty", nil, nil, nil, nil, false},
+		{"bad IP", nil, []string{"10.0.0"}, nil, nil, true},
+		{"URI without scheme", nil, nil, []string{"prod/ns/db"}, nil, true},
+		{"bad email", nil, nil, nil, []string{"proxy.example.com"}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseServerIdentity(tc.dnsNames, tc.ips, tc.uris, tc.mail)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
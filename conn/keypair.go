@@ -0,0 +1,75 @@
+package conn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// loadKeyPair reads PEM certificate chain and private key. Key may be
+// encrypted PKCS#8 which requires password.
+func loadKeyPair(certFile, keyFile, password string) (tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	for rest := keyPEM; len(rest) > 0; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "ENCRYPTED PRIVATE KEY" {
+			continue
+		}
+		if password == "" {
+			return tls.Certificate{}, fmt.Errorf("private key in file %q is encrypted, but no password given", keyFile)
+		}
+		key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(password))
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("unable to decrypt private key in file %q: %w", keyFile, err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		break
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// loadPKCS12 reads client certificate, its private key and chain
+// certificates from PKCS#12 bundle.
+func loadPKCS12(file, password string) (tls.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, cert, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to decode PKCS#12 bundle %q: %w", file, err)
+	}
+	if key == nil || cert == nil {
+		return tls.Certificate{}, errors.New("PKCS#12 bundle has no certificate or private key")
+	}
+	res := tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}
+	for _, c := range chain {
+		res.Certificate = append(res.Certificate, c.Raw)
+	}
+	return res, nil
+}
@@ -0,0 +1,61 @@
+package conn
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestLoadPKCS12(t *testing.T) {
+	pki := newTestPKI(t)
+	bundle, err := pkcs12.Modern.Encode(pki.leafKey, pki.leaf, []*x509.Certificate{pki.ca}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "client.p12")
+	if err := os.WriteFile(file, bundle, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := loadPKCS12(file, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 2 || !cert.Leaf.Equal(pki.leaf) {
+		t.Fatalf("unexpected certificate chain of length %d", len(cert.Certificate))
+	}
+	if _, err := loadPKCS12(file, "wrong"); err == nil {
+		t.Fatal("bundle decoded with wrong password")
+	}
+}
+
+func TestLoadEncryptedKey(t *testing.T) {
+	pki := newTestPKI(t)
+	der, err := pkcs8.MarshalPrivateKey(pki.leafKey, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pki.leaf.Raw})
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadKeyPair(certFile, keyFile, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"", "wrong"} {
+		if _, err := loadKeyPair(certFile, keyFile, password); err == nil {
+			t.Fatalf("key loaded with password %q", password)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package conn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	clog "github.com/Snawoot/steady-tun/log"
+)
+
+// tlsMaterial is a snapshot of client certificate and trusted roots.
+type tlsMaterial struct {
+	cert  *tls.Certificate
+	roots *x509.CertPool
+	crls  []*x509.RevocationList
+}
+
+// materialLoader keeps current client certificate and CA pool and
+// allows to replace them while factory is in use.
+type materialLoader struct {
+	certFile, keyFile string
+	pkcs12File        string
+	keyPassword       string
+	caFiles, caDirs   []string
+	caSystem          bool
+	crlFiles          []string
+	logger            *clog.CondLogger
+	current           atomic.Pointer[tlsMaterial]
+	reloadMux         sync.Mutex
+	mtimes            map[string]time.Time
+}
+
+func newMaterialLoader(opts *TLSOptions, logger *clog.CondLogger) (*materialLoader, error) {
+	if opts.CertFile != "" && opts.KeyFile == "" || opts.CertFile == "" && opts.KeyFile != "" {
+		return nil, errors.New("Certificate file and key file must be specified only together")
+	}
+	if opts.PKCS12File != "" && opts.CertFile != "" {
+		return nil, errors.New("PKCS#12 bundle can't be combined with certificate and key files")
+	}
+	l := &materialLoader{
+		certFile:    opts.CertFile,
+		keyFile:     opts.KeyFile,
+		pkcs12File:  opts.PKCS12File,
+		keyPassword: opts.KeyPassword,
+		caFiles:     opts.CAFiles,
+		caDirs:      opts.CADirs,
+		caSystem:    opts.CASystem,
+		crlFiles:    opts.CRLFiles,
+		logger:      logger,
+	}
+	m, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	l.current.Store(m)
+	l.mtimes = l.modTimes()
+	return l, nil
+}
+
+func (l *materialLoader) files() []string {
+	var res []string
+	for _, name := range []string{l.certFile, l.keyFile, l.pkcs12File} {
+		if name != "" {
+			res = append(res, name)
+		}
+	}
+	res = append(res, l.caFiles...)
+	res = append(res, l.crlFiles...)
+	for _, dir := range l.caDirs {
+		// Directory modification time changes when files are added or removed
+		res = append(res, dir)
+		if files, err := caDirFiles(dir); err == nil {
+			res = append(res, files...)
+		}
+	}
+	return res
+}
+
+func (l *materialLoader) modTimes() map[string]time.Time {
+	res := make(map[string]time.Time)
+	for _, name := range l.files() {
+		if fi, err := os.Stat(name); err == nil {
+			res[name] = fi.ModTime()
+		}
+	}
+	return res
+}
+
+func (l *materialLoader) load() (*tlsMaterial, error) {
+	m := new(tlsMaterial)
+	if l.certFile != "" || l.pkcs12File != "" {
+		var (
+			cert tls.Certificate
+			err  error
+		)
+		if l.pkcs12File != "" {
+			cert, err = loadPKCS12(l.pkcs12File, l.keyPassword)
+		} else {
+			cert, err = loadKeyPair(l.certFile, l.keyFile, l.keyPassword)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if cert.Leaf == nil {
+			cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+		m.cert = &cert
+	}
+	roots, err := l.loadCAPool()
+	if err != nil {
+		return nil, err
+	}
+	m.roots = roots
+	m.crls, err = loadCRLs(l.crlFiles)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (l *materialLoader) get() *tlsMaterial {
+	return l.current.Load()
+}
+
+// reload loads material from files. Previous material remains in use if
+// new files can't be loaded.
+func (l *materialLoader) reload(onlyModified bool) error {
+	l.reloadMux.Lock()
+	defer l.reloadMux.Unlock()
+	mtimes := l.modTimes()
+	if onlyModified {
+		modified := false
+		for name, mtime := range mtimes {
+			if !mtime.Equal(l.mtimes[name]) {
+				modified = true
+				break
+			}
+		}
+		if !modified {
+			return nil
+		}
+	}
+	// Don't retry same broken files until they change again
+	l.mtimes = mtimes
+	m, err := l.load()
+	if err != nil {
+		l.logger.Error("TLS material reload failed, keeping previous certificates: %v", err)
+		return err
+	}
+	l.current.Store(m)
+	l.logger.Info("TLS client certificate, CA certificates and CRLs reloaded.")
+	return nil
+}
+
+func (l *materialLoader) getClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if cert := l.get().cert; cert != nil {
+		return cert, nil
+	}
+	// Empty certificate means no client authentication
+	return new(tls.Certificate), nil
+}
@@ -0,0 +1,89 @@
+package conn
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMaterialReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	caFile := filepath.Join(dir, "ca.pem")
+	mtime := time.Now()
+	write := func(name string, data []byte) {
+		t.Helper()
+		if err := os.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		// Make modification visible regardless of filesystem timestamp
+		// resolution
+		mtime = mtime.Add(time.Second)
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePKI := func(pki *testPKI) {
+		t.Helper()
+		keyDER, err := x509.MarshalPKCS8PrivateKey(pki.leafKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pki.leaf.Raw}))
+		write(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+		write(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pki.ca.Raw}))
+	}
+
+	first, second, third := newTestPKI(t), newTestPKI(t), newTestPKI(t)
+	writePKI(first)
+	l, err := newMaterialLoader(&TLSOptions{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		CAFiles:  []string{caFile},
+	}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name         string
+		modify       func()
+		onlyModified bool
+		wantErr      bool
+		wantPKI      *testPKI
+	}{
+		{"unmodified", func() {}, true, false, first},
+		{"new certificate", func() { writePKI(second) }, true, false, second},
+		{"forced reload", func() {}, false, false, second},
+		{"corrupt certificate", func() { write(certFile, []byte("garbage")) }, true, true, second},
+		{"broken files not retried", func() {}, true, false, second},
+		{"broken files forced", func() {}, false, true, second},
+		{"certificate fixed", func() { writePKI(third) }, true, false, third},
+		{"key mismatch", func() {
+			keyDER, _ := x509.MarshalPKCS8PrivateKey(first.leafKey)
+			write(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+		}, true, true, third},
+		{"key restored", func() { writePKI(first) }, true, false, first},
+		{"corrupt CA file", func() { write(caFile, []byte("garbage")) }, true, true, first},
+		{"missing CA file", func() { os.Remove(caFile) }, false, true, first},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.modify()
+			err := l.reload(tc.onlyModified)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			m := l.get()
+			if !m.cert.Leaf.Equal(tc.wantPKI.leaf) {
+				t.Fatalf("unexpected client certificate %v", m.cert.Leaf.Subject)
+			}
+			if _, err := tc.wantPKI.leaf.Verify(x509.VerifyOptions{Roots: m.roots}); err != nil {
+				t.Fatalf("CA pool doesn't match client certificate: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package conn
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	clog "github.com/Snawoot/steady-tun/log"
+	"github.com/Snawoot/steady-tun/queue"
+)
+
+// RFC 8446 limits ticket lifetime to 7 days. crypto/tls additionally checks
+// actual TLS 1.3 ticket lifetime on resumption.
+const MaxSessionAge = 7 * 24 * time.Hour
+const SessionFlushInterval = 10 * time.Second
+
+type persistedSession struct {
+	Key    string    `json:"key"`
+	Ticket []byte    `json:"ticket"`
+	State  []byte    `json:"state"`
+	Saved  time.Time `json:"saved"`
+}
+
+type sessionEntry struct {
+	key   string
+	cs    *tls.ClientSessionState
+	saved time.Time
+}
+
+// PersistentSessionCache is a LRU tls.ClientSessionCache which saves
+// sessions to file and restores them on start.
+type PersistentSessionCache struct {
+	path     string
+	capacity int
+	logger   *clog.CondLogger
+	mux      sync.Mutex
+	entries  map[string]*queue.Handle[*sessionEntry]
+	lru      *queue.RAQueue[*sessionEntry]
+	dirty    bool
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+var _ tls.ClientSessionCache = &PersistentSessionCache{}
+
+func NewPersistentSessionCache(path string, capacity int, logger *clog.CondLogger) (*PersistentSessionCache, error) {
+	if capacity < 1 {
+		capacity = 1
+	}
+	c := &PersistentSessionCache{
+		path:     path,
+		capacity: capacity,
+		logger:   logger,
+		entries:  make(map[string]*queue.Handle[*sessionEntry]),
+		lru:      queue.NewRAQueue[*sessionEntry](),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	go c.loop()
+	return c, nil
+}
+
+func (c *PersistentSessionCache) load() error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("unable to read TLS session file: %w", err)
+	}
+	var saved []persistedSession
+	if err := json.Unmarshal(data, &saved); err != nil {
+		c.logger.Warning("Ignoring malformed TLS session file %q: %v", c.path, err)
+		return nil
+	}
+	now := time.Now()
+	loaded := 0
+	for _, ps := range saved {
+		if now.Sub(ps.Saved) > MaxSessionAge {
+			continue
+		}
+		state, err := tls.ParseSessionState(ps.State)
+		if err != nil {
+			c.logger.Debug("Skipping unparseable session for %q: %v", ps.Key, err)
+			continue
+		}
+		cs, err := tls.NewResumptionState(ps.Ticket, state)
+		if err != nil {
+			c.logger.Debug("Skipping session for %q: %v", ps.Key, err)
+			continue
+		}
+		c.put(ps.Key, cs, ps.Saved)
+		loaded++
+	}
+	c.dirty = false
+	c.logger.Info("Loaded %d TLS sessions from %q", loaded, c.path)
+	return nil
+}
+
+func (c *PersistentSessionCache) put(key string, cs *tls.ClientSessionState, saved time.Time) {
+	if h, ok := c.entries[key]; ok {
+		c.lru.Delete(h)
+		delete(c.entries, key)
+	}
+	c.dirty = true
+	if cs == nil {
+		return
+	}
+	c.entries[key] = c.lru.Push(&sessionEntry{
+		key:   key,
+		cs:    cs,
+		saved: saved,
+	})
+	for c.lru.Len() > c.capacity {
+		evicted, _ := c.lru.Pop()
+		delete(c.entries, evicted.key)
+	}
+}
+
+func (c *PersistentSessionCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	h, ok := c.entries[sessionKey]
+	if !ok {
+		return nil, false
+	}
+	entry, _ := c.lru.Delete(h)
+	if time.Since(entry.saved) > MaxSessionAge {
+		delete(c.entries, sessionKey)
+		c.dirty = true
+		return nil, false
+	}
+	c.entries[sessionKey] = c.lru.Push(entry)
+	return entry.cs, true
+}
+
+func (c *PersistentSessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.put(sessionKey, cs, time.Now())
+}
+
+func (c *PersistentSessionCache) snapshot() []persistedSession {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	c.dirty = false
+	res := make([]persistedSession, 0, c.lru.Len())
+	for entry := range c.lru.All() {
+		ticket, state, err := entry.cs.ResumptionState()
+		if err != nil || state == nil {
+			continue
+		}
+		stateBytes, err := state.Bytes()
+		if err != nil {
+			continue
+		}
+		res = append(res, persistedSession{
+			Key:    entry.key,
+			Ticket: ticket,
+			State:  stateBytes,
+			Saved:  entry.saved,
+		})
+	}
+	return res
+}
+
+func (c *PersistentSessionCache) flush() {
+	sessions := c.snapshot()
+	if sessions == nil {
+		return
+	}
+	if err := c.save(sessions); err != nil {
+		c.logger.Error("Unable to save TLS sessions to %q: %v", c.path, err)
+		c.mux.Lock()
+		c.dirty = true
+		c.mux.Unlock()
+	}
+}
+
+func (c *PersistentSessionCache) save(sessions []persistedSession) error {
+	data, err := json.Marshal(sessions)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), "."+filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+func (c *PersistentSessionCache) loop() {
+	defer close(c.done)
+	ticker := time.NewTicker(SessionFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-c.quit:
+			c.flush()
+			return
+		}
+	}
+}
+
+func (c *PersistentSessionCache) Close() {
+	close(c.quit)
+	<-c.done
+}
@@ -0,0 +1,107 @@
+package conn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// tls12Handshake connects to server with session cache and reports if
+// session was resumed. TLS 1.2 is used to get ticket during handshake.
+func tls12Handshake(t *testing.T, pki *testPKI, port uint16, cache tls.ClientSessionCache) bool {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.ca)
+	c, err := tls.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))), &tls.Config{
+		RootCAs:            roots,
+		ServerName:         "server.example.com",
+		MaxVersion:         tls.VersionTLS12,
+		ClientSessionCache: cache,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return c.ConnectionState().DidResume
+}
+
+func TestPersistentSessionCache(t *testing.T) {
+	pki := newTestPKI(t)
+	port, _ := startTLSServer(t, pki, nil)
+	path := filepath.Join(t.TempDir(), "sessions.json")
+
+	cache, err := NewPersistentSessionCache(path, 4, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tls12Handshake(t, pki, port, cache) {
+		t.Fatal("resumed session with empty cache")
+	}
+	cache.Close()
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sessions []persistedSession
+	if err := json.Unmarshal(saved, &sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].Key != "server.example.com" {
+		t.Fatalf("unexpected saved sessions %+v", sessions)
+	}
+	expired := append([]persistedSession(nil), sessions...)
+	expired[0].Saved = time.Now().Add(-MaxSessionAge - time.Hour)
+	expiredData, _ := json.Marshal(expired)
+	corruptState := append([]persistedSession(nil), sessions...)
+	corruptState[0].State = []byte("garbage")
+	corruptStateData, _ := json.Marshal(corruptState)
+
+	for _, tc := range []struct {
+		name       string
+		data       []byte
+		wantLoaded bool
+	}{
+		{"round trip", saved, true},
+		{"expired", expiredData, false},
+		{"corrupt file", []byte("{not json"), false},
+		{"truncated file", saved[:len(saved)/2], false},
+		{"corrupt state", corruptStateData, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sessions.json")
+			if err := os.WriteFile(path, tc.data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			cache, err := NewPersistentSessionCache(path, 4, testLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cache.Close()
+			if _, ok := cache.Get("server.example.com"); ok != tc.wantLoaded {
+				t.Fatalf("session loaded: %v, expected %v", ok, tc.wantLoaded)
+			}
+			if resumed := tls12Handshake(t, pki, port, cache); resumed != tc.wantLoaded {
+				t.Fatalf("session resumed: %v, expected %v", resumed, tc.wantLoaded)
+			}
+		})
+	}
+}
+
+func TestPersistentSessionCacheMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	cache, err := NewPersistentSessionCache(path, 4, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+	if _, err := os.Stat(path); err == nil {
+		t.Fatal("session file created without sessions")
+	}
+}
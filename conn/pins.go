@@ -0,0 +1,78 @@
+package conn
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+type PinMode int
+
+const (
+	// PinWithCA checks pins in addition to regular chain validation.
+	PinWithCA PinMode = iota
+	// PinOnly replaces CA validation with pin check.
+	PinOnly
+)
+
+func ParsePinMode(s string) (PinMode, error) {
+	switch strings.ToLower(s) {
+	case "ca", "":
+		return PinWithCA, nil
+	case "only":
+		return PinOnly, nil
+	default:
+		return 0, fmt.Errorf("unknown pin mode %q", s)
+	}
+}
+
+type spkiPin = [sha256.Size]byte
+
+// ParsePin decodes base64-encoded SHA-256 hash of certificate
+// SubjectPublicKeyInfo, optionally prefixed with "sha256/" or "sha256//".
+func ParsePin(s string) (spkiPin, error) {
+	var pin spkiPin
+	s = strings.TrimPrefix(s, "sha256/")
+	// "/" is valid base64 character, so second slash of "sha256//" prefix
+	// can be told apart only by length of encoded hash.
+	if len(s) == base64.StdEncoding.EncodedLen(sha256.Size)+1 && s[0] == '/' {
+		s = s[1:]
+	}
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return pin, fmt.Errorf("bad pin %q: %w", s, err)
+	}
+	if len(raw) != sha256.Size {
+		return pin, fmt.Errorf("bad pin %q: expected %d bytes, got %d", s, sha256.Size, len(raw))
+	}
+	copy(pin[:], raw)
+	return pin, nil
+}
+
+func certPin(cert *x509.Certificate) spkiPin {
+	return sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+}
+
+type pinSet map[spkiPin]struct{}
+
+func newPinSet(pins []string) (pinSet, error) {
+	if len(pins) == 0 {
+		return nil, nil
+	}
+	res := make(pinSet)
+	for _, s := range pins {
+		pin, err := ParsePin(s)
+		if err != nil {
+			return nil, err
+		}
+		res[pin] = struct{}{}
+	}
+	return res, nil
+}
+
+func (ps pinSet) match(cert *x509.Certificate) bool {
+	_, ok := ps[certPin(cert)]
+	return ok
+}
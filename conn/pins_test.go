@@ -0,0 +1,89 @@
+package conn
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+)
+
+func testPin(cert *x509.Certificate) string {
+	pin := certPin(cert)
+	return "sha256//" + base64.StdEncoding.EncodeToString(pin[:])
+}
+
+func TestPinnedChain(t *testing.T) {
+	pki := newTestPKI(t)
+	other := newTestPKI(t)
+	certs := []*x509.Certificate{pki.leaf, pki.ca}
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.ca)
+	material := new(materialLoader)
+	material.current.Store(&tlsMaterial{roots: roots})
+
+	for _, tc := range []struct {
+		name       string
+		mode       PinMode
+		serverName string
+		pins       []string
+		wantErr    bool
+	}{
+		{"CA leaf pin", PinWithCA, "server.example.com", []string{testPin(pki.leaf)}, false},
+		{"CA issuer pin", PinWithCA, "server.example.com", []string{testPin(pki.ca)}, false},
+		{"CA mismatch", PinWithCA, "server.example.com", []string{testPin(other.leaf)}, true},
+		{"CA wrong name", PinWithCA, "other.example.com", []string{testPin(pki.leaf)}, true},
+		{"only leaf pin", PinOnly, "server.example.com", []string{testPin(pki.leaf)}, false},
+		{"only issuer pin", PinOnly, "server.example.com", []string{testPin(pki.ca)}, false},
+		{"only mismatch", PinOnly, "server.example.com", []string{testPin(other.leaf), testPin(other.ca)}, true},
+		{"only leaf pin wrong name", PinOnly, "other.example.com", []string{testPin(pki.leaf)}, true},
+		{"only issuer pin wrong name", PinOnly, "other.example.com", []string{testPin(pki.ca)}, true},
+		{"only leaf pin no hostname check", PinOnly, "", []string{testPin(pki.leaf)}, false},
+		{"backup pin", PinOnly, "server.example.com", []string{testPin(other.leaf), testPin(pki.leaf)}, false},
+		{"CA backup pin", PinWithCA, "server.example.com", []string{testPin(other.ca), testPin(pki.ca)}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pins, err := newPinSet(tc.pins)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := &verifier{
+				material:   material,
+				serverName: tc.serverName,
+				pins:       pins,
+				pinMode:    tc.mode,
+			}
+			_, err = v.verifyTrust(certs)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestParsePin(t *testing.T) {
+	var plain, slash spkiPin
+	for i := range plain {
+		plain[i] = byte(i)
+		slash[i] = byte(0xff - i)
+	}
+	for _, want := range []spkiPin{plain, slash} {
+		encoded := base64.StdEncoding.EncodeToString(want[:])
+		for _, in := range []string{encoded, "sha256/" + encoded, "sha256//" + encoded} {
+			pin, err := ParsePin(in)
+			if err != nil {
+				t.Fatalf("ParsePin(%q): unexpected error: %v", in, err)
+			}
+			if pin != want {
+				t.Fatalf("ParsePin(%q) = %x, want %x", in, pin, want)
+			}
+		}
+	}
+	for _, bad := range []string{
+		"not base64!",
+		base64.StdEncoding.EncodeToString([]byte("short")),
+		"sha256///" + base64.StdEncoding.EncodeToString(plain[:]),
+	} {
+		if _, err := ParsePin(bad); err == nil {
+			t.Fatalf("bad pin %q accepted", bad)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package conn
 
 import (
 	"context"
-	"fmt"
 	"net"
 	"strconv"
 )
@@ -24,7 +23,7 @@ func NewPlainConnFactory(host string, port uint16, dialer ContextDialer) *PlainC
 func (cf *PlainConnFactory) DialContext(ctx context.Context) (net.Conn, error) {
 	conn, err := cf.dialer(ctx, "tcp", cf.addr)
 	if err != nil {
-		return nil, fmt.Errorf("cf.dialer.DialContext(ctx, \"tcp\", %q) failed: %v", cf.addr, err)
+		return nil, newConnectError(cf.addr, err)
 	}
 	return conn, nil
 }
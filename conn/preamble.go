@@ -0,0 +1,203 @@
+package conn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxPreambleExpect limits amount of data read while waiting for
+// expected response.
+const MaxPreambleExpect = 64 * 1024
+
+// PreambleStep either sends data or waits for it.
+type PreambleStep struct {
+	Send   []byte
+	Expect []byte
+}
+
+// Preamble is a send/expect script executed on new connections.
+type Preamble []PreambleStep
+
+// ParsePreamble reads script with one step per line: "send TEXT" or
+// "expect TEXT". TEXT may contain escapes \r, \n, \t, \\ and \xHH.
+// Empty lines and lines starting with # are ignored.
+func ParsePreamble(r io.Reader) (Preamble, error) {
+	var res Preamble
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		text, err := unescapePreamble(arg)
+		if err != nil {
+			return nil, fmt.Errorf("preamble line %d: bad text: %w", lineno, err)
+		}
+		if text == "" {
+			return nil, fmt.Errorf("preamble line %d: empty text", lineno)
+		}
+		switch cmd {
+		case "send":
+			res = append(res, PreambleStep{Send: []byte(text)})
+		case "expect":
+			if len(text) > MaxPreambleExpect {
+				return nil, fmt.Errorf("preamble line %d: expected text is too long", lineno)
+			}
+			res = append(res, PreambleStep{Expect: []byte(text)})
+		default:
+			return nil, fmt.Errorf("preamble line %d: unknown command %q", lineno, cmd)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, errors.New("empty preamble")
+	}
+	return res, nil
+}
+
+// unescapePreamble replaces escape sequences in preamble text.
+func unescapePreamble(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", errors.New("unterminated escape sequence")
+		}
+		switch s[i] {
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case '\\':
+			b.WriteByte('\\')
+		case 'x':
+			if i+2 >= len(s) {
+				return "", errors.New("short \\x escape sequence")
+			}
+			v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("bad \\x escape sequence %q", s[i-1:i+3])
+			}
+			b.WriteByte(byte(v))
+			i += 2
+		default:
+			return "", fmt.Errorf("unknown escape sequence %q", s[i-1:i+1])
+		}
+	}
+	return b.String(), nil
+}
+
+func LoadPreamble(path string) (Preamble, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParsePreamble(f)
+}
+
+// run executes script. Data is read byte by byte to leave everything
+// after expected text for the client.
+func (p Preamble) run(conn io.ReadWriter) error {
+	for _, step := range p {
+		if step.Send != nil {
+			if _, err := conn.Write(step.Send); err != nil {
+				return err
+			}
+			continue
+		}
+		var received []byte
+		buf := make([]byte, 1)
+		for !bytes.HasSuffix(received, step.Expect) {
+			if len(received) >= MaxPreambleExpect {
+				return fmt.Errorf("expected %q not received in first %d bytes", step.Expect, len(received))
+			}
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				if errors.Is(err, io.EOF) {
+					return fmt.Errorf("connection closed while waiting for %q, received %q", step.Expect, received)
+				}
+				return err
+			}
+			received = append(received, buf[0])
+		}
+	}
+	return nil
+}
+
+// PreambleFactory runs preamble on connections produced by other factory.
+type PreambleFactory struct {
+	factory  Factory
+	addr     string
+	preamble Preamble
+	timeout  time.Duration
+}
+
+var _ Factory = &PreambleFactory{}
+
+// NewPreambleFactory wraps factory dialing host and port. Address is used
+// in error reports as connection may be established through proxy.
+func NewPreambleFactory(factory Factory, host string, port uint16, preamble Preamble,
+	timeout time.Duration) *PreambleFactory {
+	return &PreambleFactory{
+		factory:  factory,
+		addr:     net.JoinHostPort(host, strconv.Itoa(int(port))),
+		preamble: preamble,
+		timeout:  timeout,
+	}
+}
+
+func (cf *PreambleFactory) DialContext(ctx context.Context) (net.Conn, error) {
+	conn, err := cf.factory.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cf.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cf.timeout)
+		defer cancel()
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	err = cf.preamble.run(conn)
+	if !stop() && err == nil {
+		// Deadline was already moved to the past
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, &DialError{
+			Stage: StagePreamble,
+			Addr:  cf.addr,
+			Err:   err,
+		}
+	}
+	return conn, nil
+}
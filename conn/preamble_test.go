@@ -0,0 +1,110 @@
+package conn
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type pipeFactory struct {
+	server func(c net.Conn)
+}
+
+func (f pipeFactory) DialContext(_ context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		f.server(server)
+	}()
+	return client, nil
+}
+
+func TestParsePreamble(t *testing.T) {
+	p, err := ParsePreamble(strings.NewReader("# login\nsend AUTH \"token\"\\r\\n\n\nexpect +OK\\r\\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 2 || string(p[0].Send) != "AUTH \"token\"\r\n" || string(p[1].Expect) != "+OK\r\n" {
+		t.Fatalf("unexpected preamble %q", p)
+	}
+	for _, bad := range []string{"", "# nothing\n", "sleep 1\n", "send\n", "send \\q\n"} {
+		if _, err := ParsePreamble(strings.NewReader(bad)); err == nil {
+			t.Errorf("bad preamble %q accepted", bad)
+		}
+	}
+}
+
+func TestUnescapePreamble(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{`plain text`, "plain text", false},
+		{`"quoted" 'text'`, `"quoted" 'text'`, false},
+		{`a\r\n\tb`, "a\r\n\tb", false},
+		{`back\\slash`, `back\slash`, false},
+		{`\x00\x7f\xFF`, "\x00\x7f\xff", false},
+		{`tail\`, "", true},
+		{`\x4`, "", true},
+		{`\xzz`, "", true},
+		{`\q`, "", true},
+	} {
+		got, err := unescapePreamble(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("unescapePreamble(%q): unexpected error: %v", tc.in, err)
+		}
+		if got != tc.want {
+			t.Fatalf("unescapePreamble(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestPreambleFactory(t *testing.T) {
+	p := Preamble{
+		{Send: []byte("HELLO\r\n")},
+		{Expect: []byte("READY\r\n")},
+	}
+	good := pipeFactory{func(c net.Conn) {
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		if line != "HELLO\r\n" {
+			return
+		}
+		io.WriteString(c, "banner\r\nREADY\r\npayload")
+	}}
+	cf := NewPreambleFactory(good, "upstream.example.com", 443, p, time.Second)
+	c, err := cf.DialContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := io.ReadAll(c)
+	if string(rest) != "payload" {
+		t.Fatalf("data after preamble lost: %q", rest)
+	}
+
+	rejecting := pipeFactory{func(c net.Conn) {
+		bufio.NewReader(c).ReadString('\n')
+		io.WriteString(c, "DENIED\r\n")
+	}}
+	_, err = NewPreambleFactory(rejecting, "upstream.example.com", 443, p, time.Second).DialContext(context.Background())
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) || dialErr.Stage != StagePreamble {
+		t.Fatalf("expected preamble error, got %v", err)
+	}
+	if dialErr.Addr != "upstream.example.com:443" {
+		t.Fatalf("unexpected error address %q", dialErr.Addr)
+	}
+
+	silent := pipeFactory{func(c net.Conn) {
+		io.Copy(io.Discard, c)
+	}}
+	_, err = NewPreambleFactory(silent, "upstream.example.com", 443, p, 50*time.Millisecond).DialContext(context.Background())
+	if !errors.As(err, &dialErr) || !dialErr.Timeout() {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package conn
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	clog "github.com/Snawoot/steady-tun/log"
+)
+
+type RevocationMode int
+
+const (
+	RevocationOff RevocationMode = iota
+	// RevocationSoft rejects only certificates known to be revoked
+	RevocationSoft
+	// RevocationHard additionally rejects certificates with unknown
+	// revocation status
+	RevocationHard
+)
+
+func ParseRevocationMode(s string) (RevocationMode, error) {
+	switch strings.ToLower(s) {
+	case "off", "":
+		return RevocationOff, nil
+	case "soft":
+		return RevocationSoft, nil
+	case "hard":
+		return RevocationHard, nil
+	default:
+		return 0, fmt.Errorf("unknown revocation check mode %q", s)
+	}
+}
+
+func (m RevocationMode) String() string {
+	switch m {
+	case RevocationOff:
+		return "off"
+	case RevocationSoft:
+		return "soft"
+	case RevocationHard:
+		return "hard"
+	default:
+		return fmt.Sprintf("RevocationMode(%d)", int(m))
+	}
+}
+
+var errRevoked = errors.New("revoked")
+
+// loadCRLs reads PEM or DER encoded certificate revocation lists.
+func loadCRLs(files []string) ([]*x509.RevocationList, error) {
+	var res []*x509.RevocationList
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Contains(data, []byte("-----BEGIN")) {
+			crl, err := x509.ParseRevocationList(data)
+			if err != nil {
+				return nil, fmt.Errorf("bad CRL in file %q: %w", name, err)
+			}
+			res = append(res, crl)
+			continue
+		}
+		for len(data) > 0 {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type != "X509 CRL" {
+				continue
+			}
+			crl, err := x509.ParseRevocationList(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("bad CRL in file %q: %w", name, err)
+			}
+			res = append(res, crl)
+		}
+	}
+	return res, nil
+}
+
+// revocationChecker verifies certificate status using stapled OCSP
+// response and CRLs.
+type revocationChecker struct {
+	ocspMode RevocationMode
+	crlMode  RevocationMode
+	logger   *clog.CondLogger
+	mux      sync.Mutex
+	warned   map[string]struct{}
+}
+
+func newRevocationChecker(ocspMode, crlMode RevocationMode, logger *clog.CondLogger) *revocationChecker {
+	if ocspMode == RevocationOff && crlMode == RevocationOff {
+		return nil
+	}
+	return &revocationChecker{
+		ocspMode: ocspMode,
+		crlMode:  crlMode,
+		logger:   logger,
+		warned:   make(map[string]struct{}),
+	}
+}
+
+// softFail returns err in hard mode and logs it once per certificate in
+// soft mode.
+func (rc *revocationChecker) softFail(mode RevocationMode, cert *x509.Certificate, err error) error {
+	if mode == RevocationHard {
+		return err
+	}
+	key := CertFingerprint(cert) + err.Error()
+	rc.mux.Lock()
+	defer rc.mux.Unlock()
+	if _, ok := rc.warned[key]; !ok {
+		rc.warned[key] = struct{}{}
+		rc.logger.Warning("Revocation status of certificate %q is unknown, accepting it anyway: %v", cert.Subject, err)
+	}
+	return nil
+}
+
+// check validates revocation status of certificates in chain. chain
+// starts with leaf and may omit root.
+func (rc *revocationChecker) check(chain []*x509.Certificate, staple []byte, crls []*x509.RevocationList) error {
+	now := time.Now()
+	if rc.ocspMode != RevocationOff {
+		if err := rc.checkOCSP(chain, staple, now); err != nil {
+			return err
+		}
+	}
+	if rc.crlMode != RevocationOff {
+		if err := rc.checkCRLs(chain, crls, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rc *revocationChecker) checkOCSP(chain []*x509.Certificate, staple []byte, now time.Time) error {
+	leaf := chain[0]
+	if len(staple) == 0 {
+		return rc.softFail(rc.ocspMode, leaf, errors.New("server did not staple OCSP response"))
+	}
+	if len(chain) < 2 {
+		return rc.softFail(rc.ocspMode, leaf, errors.New("issuer of certificate is unknown, can't verify OCSP response"))
+	}
+	resp, err := ocsp.ParseResponseForCert(staple, leaf, chain[1])
+	if err != nil {
+		return rc.softFail(rc.ocspMode, leaf, fmt.Errorf("bad stapled OCSP response: %w", err))
+	}
+	if now.Before(resp.ThisUpdate) || !resp.NextUpdate.IsZero() && now.After(resp.NextUpdate) {
+		return rc.softFail(rc.ocspMode, leaf, errors.New("stapled OCSP response is outdated"))
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		return fmt.Errorf("OCSP: certificate %w at %v", errRevoked, resp.RevokedAt)
+	default:
+		return rc.softFail(rc.ocspMode, leaf, errors.New("OCSP responder reports unknown certificate status"))
+	}
+}
+
+func (rc *revocationChecker) checkCRLs(chain []*x509.Certificate, crls []*x509.RevocationList, now time.Time) error {
+	for i, cert := range chain {
+		if i+1 >= len(chain) {
+			// Root or certificate without known issuer
+			if i == 0 {
+				return rc.softFail(rc.crlMode, cert, errors.New("issuer of certificate is unknown, can't verify CRL"))
+			}
+			break
+		}
+		issuer := chain[i+1]
+		covered := false
+		for _, crl := range crls {
+			if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) {
+				continue
+			}
+			if err := crl.CheckSignatureFrom(issuer); err != nil {
+				continue
+			}
+			if !crl.NextUpdate.IsZero() && now.After(crl.NextUpdate) {
+				continue
+			}
+			covered = true
+			for _, entry := range crl.RevokedCertificateEntries {
+				if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return fmt.Errorf("CRL: certificate %q %w at %v", cert.Subject, errRevoked, entry.RevocationTime)
+				}
+			}
+		}
+		// Only leaf certificate status is mandatory in hard mode.
+		// Intermediates are checked if their issuer CRL is available.
+		if !covered && i == 0 {
+			if err := rc.softFail(rc.crlMode, cert, errors.New("no valid CRL for certificate issuer")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
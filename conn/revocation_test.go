@@ -0,0 +1,223 @@
+package conn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	clog "github.com/Snawoot/steady-tun/log"
+)
+
+type testPKI struct {
+	caKey   crypto.Signer
+	ca      *x509.Certificate
+	leafKey crypto.Signer
+	leaf    *x509.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1000),
+		Subject:      pkix.Name{CommonName: "server"},
+		DNSNames:     []string{"server.example.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, ca, leafKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testPKI{caKey, ca, leafKey, leaf}
+}
+
+func (p *testPKI) ocspResponse(t *testing.T, status int, nextUpdate time.Time) []byte {
+	t.Helper()
+	now := time.Now()
+	resp, err := ocsp.CreateResponse(p.ca, p.ca, ocsp.Response{
+		Status:       status,
+		SerialNumber: p.leaf.SerialNumber,
+		ThisUpdate:   now.Add(-time.Minute),
+		NextUpdate:   nextUpdate,
+		RevokedAt:    now.Add(-time.Minute),
+	}, p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func (p *testPKI) crl(t *testing.T, revoked ...*big.Int) *x509.RevocationList {
+	t.Helper()
+	now := time.Now()
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: now.Add(-time.Minute),
+		NextUpdate: now.Add(time.Hour),
+	}
+	for _, serial := range revoked {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: now.Add(-time.Minute),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, p.ca, p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crl
+}
+
+func testLogger() *clog.CondLogger {
+	return clog.NewCondLogger(log.New(io.Discard, "", 0), clog.CRITICAL)
+}
+
+func TestOCSPStaple(t *testing.T) {
+	pki := newTestPKI(t)
+	chain := []*x509.Certificate{pki.leaf, pki.ca}
+	good := pki.ocspResponse(t, ocsp.Good, time.Now().Add(time.Hour))
+	revoked := pki.ocspResponse(t, ocsp.Revoked, time.Now().Add(time.Hour))
+	outdated := pki.ocspResponse(t, ocsp.Good, time.Now().Add(-time.Second))
+
+	for _, tc := range []struct {
+		name    string
+		mode    RevocationMode
+		staple  []byte
+		wantErr bool
+	}{
+		{"soft good", RevocationSoft, good, false},
+		{"hard good", RevocationHard, good, false},
+		{"soft revoked", RevocationSoft, revoked, true},
+		{"hard revoked", RevocationHard, revoked, true},
+		{"soft missing", RevocationSoft, nil, false},
+		{"hard missing", RevocationHard, nil, true},
+		{"soft outdated", RevocationSoft, outdated, false},
+		{"hard outdated", RevocationHard, outdated, true},
+		{"hard garbage", RevocationHard, []byte("garbage"), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rc := newRevocationChecker(tc.mode, RevocationOff, testLogger())
+			err := rc.check(chain, tc.staple, nil)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected result: %v", err)
+			}
+		})
+	}
+}
+
+func TestCRL(t *testing.T) {
+	pki := newTestPKI(t)
+	other := newTestPKI(t)
+	chain := []*x509.Certificate{pki.leaf, pki.ca}
+	clean := pki.crl(t, big.NewInt(1))
+	revoking := pki.crl(t, pki.leaf.SerialNumber)
+	foreign := other.crl(t, pki.leaf.SerialNumber)
+
+	for _, tc := range []struct {
+		name    string
+		mode    RevocationMode
+		crls    []*x509.RevocationList
+		wantErr bool
+	}{
+		{"soft clean", RevocationSoft, []*x509.RevocationList{clean}, false},
+		{"hard clean", RevocationHard, []*x509.RevocationList{clean}, false},
+		{"soft revoked", RevocationSoft, []*x509.RevocationList{revoking}, true},
+		{"soft missing", RevocationSoft, nil, false},
+		{"hard missing", RevocationHard, nil, true},
+		{"hard foreign", RevocationHard, []*x509.RevocationList{foreign}, true},
+		{"soft foreign", RevocationSoft, []*x509.RevocationList{foreign}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rc := newRevocationChecker(RevocationOff, tc.mode, testLogger())
+			err := rc.check(chain, nil, tc.crls)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected result: %v", err)
+			}
+			if tc.name == "soft revoked" && !errors.Is(err, errRevoked) {
+				t.Fatalf("expected revocation error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadCRLs(t *testing.T) {
+	pki := newTestPKI(t)
+	crl := pki.crl(t, pki.leaf.SerialNumber)
+	dir := t.TempDir()
+	derFile := filepath.Join(dir, "crl.der")
+	pemFile := filepath.Join(dir, "crl.pem")
+	if err := os.WriteFile(derFile, crl.Raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl.Raw})
+	if err := os.WriteFile(pemFile, pemData, 0600); err != nil {
+		t.Fatal(err)
+	}
+	crls, err := loadCRLs([]string{derFile, pemFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crls) != 2 {
+		t.Fatalf("expected 2 CRLs, got %d", len(crls))
+	}
+}
+
+func TestHardCRLModeRequiresFiles(t *testing.T) {
+	_, err := NewTLSConnFactory("localhost", 443, nil, TLSOptions{
+		HostnameCheck: true,
+		Dialers:       1,
+		CRLMode:       RevocationHard,
+	}, testLogger())
+	if err == nil {
+		t.Fatal("hard CRL mode without CRL files was accepted")
+	}
+}
@@ -0,0 +1,34 @@
+package conn
+
+import (
+	"crypto/tls"
+	"sync"
+)
+
+// notifyingSessionCache signals when first session ticket gets stored
+// into underlying cache.
+type notifyingSessionCache struct {
+	tls.ClientSessionCache
+	once  sync.Once
+	ready chan struct{}
+}
+
+func newNotifyingSessionCache(cache tls.ClientSessionCache) *notifyingSessionCache {
+	return &notifyingSessionCache{
+		ClientSessionCache: cache,
+		ready:              make(chan struct{}),
+	}
+}
+
+func (c *notifyingSessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	c.ClientSessionCache.Put(sessionKey, cs)
+	if cs != nil {
+		c.markReady()
+	}
+}
+
+func (c *notifyingSessionCache) markReady() {
+	c.once.Do(func() {
+		close(c.ready)
+	})
+}
@@ -0,0 +1,73 @@
+package conn
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestNotifyingSessionCache(t *testing.T) {
+	c := newNotifyingSessionCache(tls.NewLRUClientSessionCache(4))
+	c.Put("a", nil)
+	if isClosed(c.ready) {
+		t.Fatal("ready after session removal")
+	}
+	c.Put("a", new(tls.ClientSessionState))
+	if !isClosed(c.ready) {
+		t.Fatal("not ready after first session ticket")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("session wasn't stored into underlying cache")
+	}
+	// Subsequent tickets and timer fallback must not close channel again
+	c.Put("b", new(tls.ClientSessionState))
+	c.markReady()
+}
+
+func TestWarmedUp(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		cache     tls.ClientSessionCache
+		warmup    time.Duration
+		wantGated bool
+	}{
+		{"no session cache", nil, time.Second, false},
+		{"no warm-up period", tls.NewLRUClientSessionCache(4), 0, false},
+		{"session cache with warm-up", tls.NewLRUClientSessionCache(4), time.Second, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cf, err := NewTLSConnFactory("localhost", 443, nil, TLSOptions{
+				HostnameCheck: true,
+				Dialers:       1,
+				SessionCache:  tc.cache,
+				SessionWarmup: tc.warmup,
+			}, testLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			warmed := cf.WarmedUp()
+			if (warmed != nil) != tc.wantGated {
+				t.Fatalf("unexpected warm-up channel %v", warmed)
+			}
+			if warmed == nil {
+				return
+			}
+			if isClosed(warmed) {
+				t.Fatal("warmed up before first session ticket")
+			}
+			cf.sessionCache.Put("localhost:443", new(tls.ClientSessionState))
+			if !isClosed(warmed) {
+				t.Fatal("not warmed up after first session ticket")
+			}
+		})
+	}
+}
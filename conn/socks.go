@@ -0,0 +1,58 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// dialerAdapter makes ContextDialer usable as forward dialer of
+// golang.org/x/net/proxy.
+type dialerAdapter ContextDialer
+
+func (d dialerAdapter) Dial(network, address string) (net.Conn, error) {
+	return d(context.Background(), network, address)
+}
+
+func (d dialerAdapter) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d(ctx, network, address)
+}
+
+// NewSOCKS5Dialer returns dialer which connects through SOCKS5 proxy
+// reached with forward dialer. Empty username disables authentication.
+// Hostnames are passed to proxy as is, so destination is resolved by
+// proxy unless dialer is wrapped with local resolver. Non-zero timeout
+// limits whole connection setup including SOCKS negotiation.
+func NewSOCKS5Dialer(proxyAddr, username, password string, timeout time.Duration,
+	forward ContextDialer) (ContextDialer, error) {
+	var auth *proxy.Auth
+	if username != "" {
+		auth = &proxy.Auth{
+			User:     username,
+			Password: password,
+		}
+	}
+	d, err := proxy.SOCKS5("tcp", proxyAddr, auth, dialerAdapter(forward))
+	if err != nil {
+		return nil, err
+	}
+	cd, ok := d.(proxy.ContextDialer)
+	if !ok {
+		return nil, errors.New("SOCKS5 dialer doesn't support context")
+	}
+	return withTimeout(cd.DialContext, timeout), nil
+}
+
+func withTimeout(dialer ContextDialer, timeout time.Duration) ContextDialer {
+	if timeout <= 0 {
+		return dialer
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return dialer(ctx, network, address)
+	}
+}
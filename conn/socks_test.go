@@ -0,0 +1,158 @@
+package conn
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Snawoot/steady-tun/dnscache"
+)
+
+// startTestSOCKS5 runs minimal SOCKS5 server requiring user/password
+// authentication. It reports requested destinations to channel.
+func startTestSOCKS5(t *testing.T, user, password string) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	requests := make(chan string, 10)
+	serve := func(c net.Conn) {
+		defer c.Close()
+		buf := make([]byte, 512)
+		// Greeting: VER NMETHODS METHODS...
+		if _, err := io.ReadFull(c, buf[:2]); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(c, buf[:buf[1]]); err != nil {
+			return
+		}
+		c.Write([]byte{5, 2})
+		// RFC 1929: VER ULEN UNAME PLEN PASSWD
+		io.ReadFull(c, buf[:2])
+		gotUser := make([]byte, buf[1])
+		io.ReadFull(c, gotUser)
+		io.ReadFull(c, buf[:1])
+		gotPassword := make([]byte, buf[0])
+		io.ReadFull(c, gotPassword)
+		if string(gotUser) != user || string(gotPassword) != password {
+			c.Write([]byte{1, 1})
+			return
+		}
+		c.Write([]byte{1, 0})
+		// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+		if _, err := io.ReadFull(c, buf[:4]); err != nil {
+			return
+		}
+		var host string
+		switch buf[3] {
+		case 1:
+			io.ReadFull(c, buf[:4])
+			host = net.IP(buf[:4]).String()
+		case 3:
+			io.ReadFull(c, buf[:1])
+			n := buf[0]
+			io.ReadFull(c, buf[:n])
+			host = string(buf[:n])
+		case 4:
+			io.ReadFull(c, buf[:16])
+			host = net.IP(buf[:16]).String()
+		}
+		io.ReadFull(c, buf[:2])
+		dst := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+		requests <- dst
+		upstream, err := net.Dial("tcp", dst)
+		if err != nil {
+			c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer upstream.Close()
+		c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		go io.Copy(upstream, c)
+		io.Copy(c, upstream)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(c)
+		}
+	}()
+	return ln.Addr().String(), requests
+}
+
+func startEchoServer(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestSOCKS5Dialer(t *testing.T) {
+	echoPort := startEchoServer(t)
+	proxyAddr, requests := startTestSOCKS5(t, "user", "secret")
+	direct := (&net.Dialer{}).DialContext
+	socks, err := NewSOCKS5Dialer(proxyAddr, "user", "secret", time.Second, direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		dialer ContextDialer
+		want   string
+	}{
+		{"remote resolution", socks, net.JoinHostPort("localhost", strconv.Itoa(echoPort))},
+		{"local resolution", dnscache.WrapDialerNoCache(socks, net.DefaultResolver, time.Second),
+			net.JoinHostPort("127.0.0.1", strconv.Itoa(echoPort))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Force IPv4 so that localhost is resolved to 127.0.0.1
+			cf := NewPlainConnFactory("localhost", uint16(echoPort), func(ctx context.Context, network, address string) (net.Conn, error) {
+				return tc.dialer(ctx, "tcp4", address)
+			})
+			c, err := cf.DialContext(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if got := <-requests; got != tc.want {
+				t.Fatalf("proxy was asked for %q, want %q", got, tc.want)
+			}
+			io.WriteString(c, "ping")
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+				t.Fatalf("unexpected echo %q: %v", buf, err)
+			}
+		})
+	}
+
+	bad, err := NewSOCKS5Dialer(proxyAddr, "user", "wrong", time.Second, direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bad(context.Background(), "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(echoPort))); err == nil {
+		t.Fatal("connection with wrong password succeeded")
+	}
+}
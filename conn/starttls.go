@@ -0,0 +1,293 @@
+package conn
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// StartTLS selects protocol-level upgrade performed before TLS handshake.
+type StartTLS int
+
+const (
+	StartTLSNone StartTLS = iota
+	StartTLSSMTP
+	StartTLSIMAP
+	StartTLSPOP3
+	StartTLSXMPP
+	StartTLSPostgres
+)
+
+func ParseStartTLS(s string) (StartTLS, error) {
+	switch strings.ToLower(s) {
+	case "none", "":
+		return StartTLSNone, nil
+	case "smtp":
+		return StartTLSSMTP, nil
+	case "imap":
+		return StartTLSIMAP, nil
+	case "pop3":
+		return StartTLSPOP3, nil
+	case "xmpp":
+		return StartTLSXMPP, nil
+	case "postgres", "postgresql":
+		return StartTLSPostgres, nil
+	default:
+		return 0, fmt.Errorf("unknown STARTTLS protocol %q", s)
+	}
+}
+
+// errStartTLSRefused indicates server which doesn't offer or refuses
+// STARTTLS. It is not going to change on retry.
+var errStartTLSRefused = errors.New("STARTTLS refused")
+
+func refused(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{errStartTLSRefused}, args...)...)
+}
+
+// startTLS runs negotiation on conn. Connection deadline follows ctx.
+func startTLS(ctx context.Context, proto StartTLS, conn net.Conn, host string) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Unblock I/O on cancellation
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+	defer conn.SetDeadline(time.Time{})
+	var err error
+	switch proto {
+	case StartTLSSMTP:
+		err = startTLSSMTP(conn)
+	case StartTLSIMAP:
+		err = startTLSIMAP(conn)
+	case StartTLSPOP3:
+		err = startTLSPOP3(conn)
+	case StartTLSXMPP:
+		err = startTLSXMPP(conn, host)
+	case StartTLSPostgres:
+		err = startTLSPostgres(conn)
+	}
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Server must not send anything after positive reply until client
+// hello, so buffered readers below don't consume TLS data.
+
+// readSMTPReply reads possibly multiline reply and returns its code and
+// lines without code.
+func readSMTPReply(r *bufio.Reader) (string, []string, error) {
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) < 3 {
+			return "", nil, fmt.Errorf("malformed SMTP reply %q", line)
+		}
+		if len(line) > 3 {
+			lines = append(lines, line[4:])
+		}
+		if len(line) == 3 || line[3] == ' ' {
+			return line[:3], lines, nil
+		}
+	}
+}
+
+func startTLSSMTP(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	code, lines, err := readSMTPReply(r)
+	if err != nil {
+		return err
+	}
+	if code != "220" {
+		return refused("SMTP greeting: %s %s", code, strings.Join(lines, " "))
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "localhost"
+	}
+	if _, err := fmt.Fprintf(conn, "EHLO %s\r\n", hostname); err != nil {
+		return err
+	}
+	code, lines, err = readSMTPReply(r)
+	if err != nil {
+		return err
+	}
+	if code != "250" {
+		return refused("SMTP EHLO: %s %s", code, strings.Join(lines, " "))
+	}
+	supported := false
+	for _, ext := range lines {
+		if strings.EqualFold(strings.TrimSpace(ext), "STARTTLS") {
+			supported = true
+		}
+	}
+	if !supported {
+		return refused("SMTP server doesn't offer STARTTLS")
+	}
+	if _, err := io.WriteString(conn, "STARTTLS\r\n"); err != nil {
+		return err
+	}
+	code, lines, err = readSMTPReply(r)
+	if err != nil {
+		return err
+	}
+	if code != "220" {
+		return refused("SMTP STARTTLS: %s %s", code, strings.Join(lines, " "))
+	}
+	return nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func startTLSIMAP(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	greeting, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return refused("IMAP greeting: %s", greeting)
+	}
+	if _, err := io.WriteString(conn, "a001 STARTTLS\r\n"); err != nil {
+		return err
+	}
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		// Skip untagged responses
+		if strings.HasPrefix(line, "* ") {
+			continue
+		}
+		if strings.HasPrefix(line, "a001 OK") {
+			return nil
+		}
+		return refused("IMAP STARTTLS: %s", line)
+	}
+}
+
+func startTLSPOP3(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	greeting, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "+OK") {
+		return refused("POP3 greeting: %s", greeting)
+	}
+	if _, err := io.WriteString(conn, "STLS\r\n"); err != nil {
+		return err
+	}
+	reply, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(reply, "+OK") {
+		return refused("POP3 STLS: %s", reply)
+	}
+	return nil
+}
+
+const (
+	xmppStreamsNS = "http://etherx.jabber.org/streams"
+	xmppTLSNS     = "urn:ietf:params:xml:ns:xmpp-tls"
+)
+
+func startTLSXMPP(conn net.Conn, host string) error {
+	var domain strings.Builder
+	xml.EscapeText(&domain, []byte(host))
+	_, err := fmt.Fprintf(conn, "<?xml version='1.0'?><stream:stream to='%s' version='1.0' "+
+		"xmlns='jabber:client' xmlns:stream='%s'>", domain.String(), xmppStreamsNS)
+	if err != nil {
+		return err
+	}
+	dec := xml.NewDecoder(bufio.NewReader(conn))
+	next := func() (xml.StartElement, error) {
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return xml.StartElement{}, err
+			}
+			if se, ok := tok.(xml.StartElement); ok {
+				return se, nil
+			}
+		}
+	}
+	se, err := next()
+	if err != nil {
+		return err
+	}
+	if se.Name.Space != xmppStreamsNS || se.Name.Local != "stream" {
+		return fmt.Errorf("unexpected XMPP element <%s>", se.Name.Local)
+	}
+	se, err = next()
+	if err != nil {
+		return err
+	}
+	if se.Name.Space != xmppStreamsNS || se.Name.Local != "features" {
+		return refused("expected XMPP stream features, got <%s>", se.Name.Local)
+	}
+	var features struct {
+		StartTLS *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+	}
+	if err := dec.DecodeElement(&features, &se); err != nil {
+		return err
+	}
+	if features.StartTLS == nil {
+		return refused("XMPP server doesn't offer STARTTLS")
+	}
+	if _, err := fmt.Fprintf(conn, "<starttls xmlns='%s'/>", xmppTLSNS); err != nil {
+		return err
+	}
+	se, err = next()
+	if err != nil {
+		return err
+	}
+	if se.Name.Space != xmppTLSNS || se.Name.Local != "proceed" {
+		return refused("XMPP STARTTLS: <%s>", se.Name.Local)
+	}
+	return nil
+}
+
+const postgresSSLRequestCode = 80877103
+
+func startTLSPostgres(conn net.Conn) error {
+	var req [8]byte
+	binary.BigEndian.PutUint32(req[0:4], 8)
+	binary.BigEndian.PutUint32(req[4:8], postgresSSLRequestCode)
+	if _, err := conn.Write(req[:]); err != nil {
+		return err
+	}
+	var resp [1]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return err
+	}
+	switch resp[0] {
+	case 'S':
+		return nil
+	case 'N':
+		return refused("PostgreSQL server doesn't support SSL")
+	default:
+		return fmt.Errorf("unexpected PostgreSQL SSLRequest response %q", resp[0])
+	}
+}
@@ -0,0 +1,203 @@
+package conn
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer is a script of server side of STARTTLS negotiation. It
+// returns false if negotiation was refused.
+type fakeServer func(t *testing.T, r *bufio.Reader, w io.Writer) bool
+
+func expectLine(t *testing.T, r *bufio.Reader, prefix string) {
+	t.Helper()
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Errorf("read failed: %v", err)
+		return
+	}
+	if !strings.HasPrefix(line, prefix) {
+		t.Errorf("expected %q, got %q", prefix, line)
+	}
+}
+
+func fakeSMTP(offer bool) fakeServer {
+	return func(t *testing.T, r *bufio.Reader, w io.Writer) bool {
+		io.WriteString(w, "220-mail.example.com ESMTP\r\n220 ready\r\n")
+		expectLine(t, r, "EHLO ")
+		io.WriteString(w, "250-mail.example.com\r\n250-PIPELINING\r\n")
+		if offer {
+			io.WriteString(w, "250-STARTTLS\r\n")
+		}
+		io.WriteString(w, "250 8BITMIME\r\n")
+		if !offer {
+			return false
+		}
+		expectLine(t, r, "STARTTLS\r\n")
+		io.WriteString(w, "220 go ahead\r\n")
+		return true
+	}
+}
+
+func fakeIMAP(t *testing.T, r *bufio.Reader, w io.Writer) bool {
+	io.WriteString(w, "* OK IMAP4rev1 ready\r\n")
+	expectLine(t, r, "a001 STARTTLS\r\n")
+	io.WriteString(w, "* CAPABILITY IMAP4rev1\r\na001 OK begin TLS\r\n")
+	return true
+}
+
+func fakePOP3(t *testing.T, r *bufio.Reader, w io.Writer) bool {
+	io.WriteString(w, "+OK POP3 ready\r\n")
+	expectLine(t, r, "STLS\r\n")
+	io.WriteString(w, "+OK begin TLS\r\n")
+	return true
+}
+
+func fakeXMPP(offer bool) fakeServer {
+	return func(t *testing.T, r *bufio.Reader, w io.Writer) bool {
+		stream, err := r.ReadString('>')
+		if err == nil && strings.HasPrefix(stream, "<?xml") {
+			stream, err = r.ReadString('>')
+		}
+		if err != nil || !strings.Contains(stream, "to='server.example.com'") {
+			t.Errorf("unexpected stream header %q: %v", stream, err)
+		}
+		io.WriteString(w, "<?xml version='1.0'?><stream:stream from='server.example.com' id='1' version='1.0' "+
+			"xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams'><stream:features>")
+		if offer {
+			io.WriteString(w, "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls>")
+		}
+		io.WriteString(w, "<mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>PLAIN</mechanism>"+
+			"</mechanisms></stream:features>")
+		if !offer {
+			return false
+		}
+		if req, err := r.ReadString('>'); err != nil || !strings.Contains(req, "starttls") {
+			t.Errorf("unexpected STARTTLS request %q: %v", req, err)
+		}
+		io.WriteString(w, "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+		return true
+	}
+}
+
+func fakePostgres(accept bool) fakeServer {
+	return func(t *testing.T, r *bufio.Reader, w io.Writer) bool {
+		var req [8]byte
+		if _, err := io.ReadFull(r, req[:]); err != nil {
+			t.Errorf("read failed: %v", err)
+		}
+		if binary.BigEndian.Uint32(req[4:]) != postgresSSLRequestCode {
+			t.Errorf("unexpected request %x", req)
+		}
+		if !accept {
+			w.Write([]byte{'N'})
+			return false
+		}
+		w.Write([]byte{'S'})
+		return true
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		proto   StartTLS
+		server  fakeServer
+		refused bool
+	}{
+		{"smtp", StartTLSSMTP, fakeSMTP(true), false},
+		{"smtp no starttls", StartTLSSMTP, fakeSMTP(false), true},
+		{"imap", StartTLSIMAP, fakeIMAP, false},
+		{"pop3", StartTLSPOP3, fakePOP3, false},
+		{"xmpp", StartTLSXMPP, fakeXMPP(true), false},
+		{"xmpp no starttls", StartTLSXMPP, fakeXMPP(false), true},
+		{"postgres", StartTLSPostgres, fakePostgres(true), false},
+		{"postgres no ssl", StartTLSPostgres, fakePostgres(false), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go func() {
+				defer server.Close()
+				tc.server(t, bufio.NewReader(server), server)
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := startTLS(ctx, tc.proto, client, "server.example.com")
+			if tc.refused != errors.Is(err, errStartTLSRefused) || !tc.refused && err != nil {
+				t.Fatalf("unexpected result: %v", err)
+			}
+		})
+	}
+}
+
+func TestStartTLSTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := startTLS(ctx, StartTLSSMTP, client, "server.example.com")
+	if err == nil || !newStartTLSError("server.example.com:25", err).Timeout() {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+}
+
+func TestStartTLSDial(t *testing.T) {
+	pki := newTestPKI(t)
+	caFile := writeCAFile(t, pki)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if !fakeSMTP(true)(t, bufio.NewReader(c), c) {
+			return
+		}
+		tlsConn := tls.Server(c, &tls.Config{
+			Certificates: []tls.Certificate{{
+				Certificate: [][]byte{pki.leaf.Raw},
+				PrivateKey:  pki.leafKey,
+			}},
+		})
+		io.Copy(tlsConn, tlsConn)
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+	cf, err := NewTLSConnFactory("127.0.0.1", uint16(port), (&net.Dialer{}).DialContext, TLSOptions{
+		CAFiles:          []string{caFile},
+		HostnameCheck:    true,
+		ServerName:       "server.example.com",
+		Dialers:          1,
+		HandshakeTimeout: 5 * time.Second,
+		StartTLS:         StartTLSSMTP,
+	}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cf.DialContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := io.WriteString(c, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("unexpected echo %q: %v", buf, err)
+	}
+}
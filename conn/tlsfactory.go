@@ -3,95 +3,306 @@ package conn
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"errors"
-	"fmt"
-	"io/ioutil"
+	"io"
 	"net"
 	"strconv"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/sync/semaphore"
 
+	utls "github.com/refraction-networking/utls"
+
 	clog "github.com/Snawoot/steady-tun/log"
 )
 
 type TLSConnFactory struct {
-	addr      string
-	tlsConfig *tls.Config
-	dialer    ContextDialer
-	sem       *semaphore.Weighted
+	addr         string
+	tlsConfig    *tls.Config
+	fingerprint  Fingerprint
+	startTLS     StartTLS
+	utlsConfig   *utls.Config
+	dialer       ContextDialer
+	sem          *semaphore.Weighted
+	hsTimeout    time.Duration
+	sessionCache *notifyingSessionCache
+	material     *materialLoader
+	clientExpiry *expiryMonitor
+	serverExpiry *expiryMonitor
+	warmup       time.Duration
+	echConfig    atomic.Pointer[[]byte]
+	logger       *clog.CondLogger
+	fullCount    atomic.Uint64
+	resumedCount atomic.Uint64
+}
+
+type TLSStats struct {
+	FullHandshakes    uint64
+	ResumedHandshakes uint64
+	// Zero if unknown
+	ClientCertNotAfter time.Time
+	ServerCertNotAfter time.Time
 }
 
 var _ Factory = &TLSConnFactory{}
 
+type TLSOptions struct {
+	CertFile, KeyFile string
+	// PKCS12File is alternative source of client certificate, key and
+	// chain certificates
+	PKCS12File string
+	// KeyPassword decrypts PKCS#12 bundle or encrypted PKCS#8 key
+	KeyPassword string
+	// CAFiles and CADirs replace system CA certificates unless
+	// CASystem is set
+	CAFiles       []string
+	CADirs        []string
+	CASystem      bool
+	HostnameCheck bool
+	ServerName    string
+	// Dialers limits number of concurrent connection attempts
+	Dialers uint
+	// DialSemaphore, if set, is used instead of Dialers limit. It allows
+	// several factories to share same limit.
+	DialSemaphore    *semaphore.Weighted
+	SessionCache     tls.ClientSessionCache
+	SessionWarmup    time.Duration
+	HandshakeTimeout time.Duration
+	// Pins are base64-encoded SHA-256 hashes of server SPKI
+	Pins    []string
+	PinMode PinMode
+	// KnownHosts enables trust-on-first-use verification instead of CA
+	// validation. It may be shared by factories dialing same upstream.
+	KnownHosts *KnownHosts
+	// ServerIdentity restricts accepted server certificate SANs
+	// regardless of dialed hostname
+	ServerIdentity *ServerIdentity
+	// OCSPMode controls enforcement of stapled OCSP response
+	OCSPMode RevocationMode
+	CRLFiles []string
+	CRLMode  RevocationMode
+	// DANEMode enables server certificate verification against TLSA
+	// records, looked up using DNSSEC-validating resolver DNSServer
+	DANEMode   DANEMode
+	DNSServer  string
+	DNSTimeout time.Duration
+	// ECHConfigList enables Encrypted Client Hello
+	ECHConfigList []byte
+	// Zero values keep crypto/tls defaults
+	MinVersion       uint16
+	MaxVersion       uint16
+	CipherSuites     []uint16
+	CurvePreferences []tls.CurveID
+	NextProtos       []string
+	// StartTLS protocol upgrade is performed before handshake and
+	// counts against HandshakeTimeout
+	StartTLS StartTLS
+	// Fingerprint other than FingerprintGo makes ClientHello mimic
+	// browser. Such handshakes don't use SessionCache, CipherSuites and
+	// CurvePreferences.
+	Fingerprint Fingerprint
+	// KeyLogWriter receives TLS secrets in NSS key log format. It
+	// compromises security and is meant for debugging only.
+	KeyLogWriter io.Writer
+	// ExpiryThresholds specify remaining validity periods of client and
+	// server certificates which trigger warning
+	ExpiryThresholds []time.Duration
+}
+
 func NewTLSConnFactory(host string, port uint16, dialer ContextDialer,
-	certfile, keyfile string, cafile string, hostname_check bool,
-	tls_servername string, dialers uint, sessionCache tls.ClientSessionCache, logger *clog.CondLogger) (*TLSConnFactory, error) {
-	if !hostname_check && cafile == "" {
+	opts TLSOptions, logger *clog.CondLogger) (*TLSConnFactory, error) {
+	pins, err := newPinSet(opts.Pins)
+	if err != nil {
+		return nil, err
+	}
+	pinOnly := len(pins) > 0 && opts.PinMode == PinOnly
+	if opts.KnownHosts != nil && len(pins) > 0 {
+		return nil, errors.New("Trust-on-first-use mode can't be combined with pinning")
+	}
+	if opts.DANEMode != DANEOff && (opts.KnownHosts != nil || pinOnly) {
+		return nil, errors.New("DANE can't be combined with trust-on-first-use or pin-only mode")
+	}
+	if opts.CRLMode == RevocationHard && len(opts.CRLFiles) == 0 {
+		return nil, errors.New("Hard CRL check mode requires CRL files")
+	}
+	customCA := len(opts.CAFiles) > 0 || len(opts.CADirs) > 0
+	if !opts.HostnameCheck && !customCA && !pinOnly && opts.KnownHosts == nil && opts.DANEMode != DANEOnly {
 		return nil, errors.New("Hostname check should not be disabled in absence of custom CA file")
 	}
-	if certfile != "" && keyfile == "" || certfile == "" && keyfile != "" {
-		return nil, errors.New("Certificate file and key file must be specified only together")
+	// System roots vouch for any public hostname, so peer has to be
+	// restricted by other means
+	peerRestricted := len(pins) > 0 || opts.KnownHosts != nil || opts.DANEMode == DANEOnly ||
+		!opts.ServerIdentity.Empty()
+	if !opts.HostnameCheck && opts.CASystem && !peerRestricted {
+		return nil, errors.New("Hostname check should not be disabled when system CA certificates are trusted")
 	}
-	var certs []tls.Certificate
-	if certfile != "" && keyfile != "" {
-		cert, err := tls.LoadX509KeyPair(certfile, keyfile)
-		if err != nil {
-			return nil, err
-		}
-		certs = append(certs, cert)
+	material, err := newMaterialLoader(&opts, logger)
+	if err != nil {
+		return nil, err
 	}
-	var roots *x509.CertPool
-	if cafile != "" {
-		roots = x509.NewCertPool()
-		certs, err := ioutil.ReadFile(cafile)
-		if err != nil {
-			return nil, err
+	servername := host
+	if opts.ServerName != "" {
+		servername = opts.ServerName
+	}
+	if opts.Fingerprint != FingerprintGo {
+		if opts.SessionCache != nil || len(opts.CipherSuites) > 0 || len(opts.CurvePreferences) > 0 {
+			return nil, errors.New("Session cache, cipher suites and curves can't be customized with ClientHello fingerprint")
 		}
-		if ok := roots.AppendCertsFromPEM(certs); !ok {
-			return nil, errors.New("Failed to load CA certificates")
+		if opts.ECHConfigList != nil {
+			return nil, errors.New("Encrypted Client Hello can't be combined with ClientHello fingerprint")
 		}
 	}
-	servername := host
-	if tls_servername != "" {
-		servername = tls_servername
+	var notifyingCache *notifyingSessionCache
+	if opts.MinVersion != 0 && opts.MaxVersion != 0 && opts.MinVersion > opts.MaxVersion {
+		return nil, errors.New("Minimal TLS version is greater than maximal TLS version")
 	}
 	tlsConfig := tls.Config{
-		RootCAs:            roots,
-		ServerName:         servername,
-		Certificates:       certs,
-		ClientSessionCache: sessionCache,
+		ServerName:           servername,
+		GetClientCertificate: material.getClientCertificate,
+		MinVersion:           opts.MinVersion,
+		MaxVersion:           opts.MaxVersion,
+		CipherSuites:         opts.CipherSuites,
+		CurvePreferences:     opts.CurvePreferences,
+		NextProtos:           opts.NextProtos,
+		KeyLogWriter:         opts.KeyLogWriter,
+	}
+	if opts.SessionCache != nil {
+		notifyingCache = newNotifyingSessionCache(opts.SessionCache)
+		tlsConfig.ClientSessionCache = notifyingCache
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	if len(opts.CRLFiles) > 0 && opts.CRLMode == RevocationOff {
+		opts.CRLMode = RevocationSoft
 	}
-	if !hostname_check {
+	revocation := newRevocationChecker(opts.OCSPMode, opts.CRLMode, logger)
+	dane := newDANEVerifier(opts.DANEMode, opts.DNSServer, opts.DNSTimeout, host, port)
+	v := &verifier{
+		material:   material,
+		pins:       pins,
+		pinMode:    opts.PinMode,
+		knownHosts: opts.KnownHosts,
+		hostport:   addr,
+		identity:   opts.ServerIdentity,
+		revocation: revocation,
+		dane:       dane,
+	}
+	if opts.HostnameCheck {
+		v.serverName = servername
+	}
+	// Custom CA certificates may be reloaded, so chain has to be verified
+	// on our own.
+	if !opts.HostnameCheck || len(pins) > 0 || opts.KnownHosts != nil || customCA ||
+		!opts.ServerIdentity.Empty() || revocation != nil || dane != nil {
 		tlsConfig.InsecureSkipVerify = true
-		tlsConfig.VerifyPeerCertificate = func(certificates [][]byte, _ [][]*x509.Certificate) error {
-			certs := make([]*x509.Certificate, len(certificates))
-			for i, asn1Data := range certificates {
-				cert, err := x509.ParseCertificate(asn1Data)
-				if err != nil {
-					return errors.New("tls: failed to parse certificate from server: " + err.Error())
-				}
-				certs[i] = cert
-			}
+		tlsConfig.VerifyConnection = v.verifyConnection
+	}
+	if opts.ECHConfigList != nil {
+		if opts.MaxVersion != 0 && opts.MaxVersion < tls.VersionTLS13 {
+			return nil, errors.New("Encrypted Client Hello requires TLS 1.3")
+		}
+		tlsConfig.MinVersion = tls.VersionTLS13
+	}
+	cf := &TLSConnFactory{
+		addr:         addr,
+		tlsConfig:    &tlsConfig,
+		fingerprint:  opts.Fingerprint,
+		startTLS:     opts.StartTLS,
+		dialer:       dialer,
+		sem:          opts.DialSemaphore,
+		hsTimeout:    opts.HandshakeTimeout,
+		sessionCache: notifyingCache,
+		material:     material,
+		clientExpiry: newExpiryMonitor("Client", opts.ExpiryThresholds, logger),
+		serverExpiry: newExpiryMonitor("Server", opts.ExpiryThresholds, logger),
+		warmup:       opts.SessionWarmup,
+		logger:       logger,
+	}
+	if cf.sem == nil {
+		cf.sem = semaphore.NewWeighted(int64(opts.Dialers))
+	}
+	if opts.Fingerprint != FingerprintGo {
+		cf.utlsConfig = newUTLSConfig(&tlsConfig, material, v)
+		if _, err := fingerprintSpec(opts.Fingerprint, cf.utlsConfig); err != nil {
+			return nil, err
+		}
+	}
+	if opts.ECHConfigList != nil {
+		echConfig := opts.ECHConfigList
+		cf.echConfig.Store(&echConfig)
+		tlsConfig.EncryptedClientHelloRejectionVerify = cf.verifyECHRejection
+	}
+	cf.checkClientCert()
+	return cf, nil
+}
 
-			opts := x509.VerifyOptions{
-				Roots:         roots, // On the server side, use config.ClientCAs.
-				DNSName:       "",    // No hostname check
-				Intermediates: x509.NewCertPool(),
-			}
-			for _, cert := range certs[1:] {
-				opts.Intermediates.AddCert(cert)
-			}
-			_, err := certs[0].Verify(opts)
-			return err
+func (cf *TLSConnFactory) checkClientCert() {
+	if cert := cf.material.get().cert; cert != nil {
+		cf.clientExpiry.check(cert.Leaf)
+	}
+}
+
+// WarmedUp returns channel which gets closed once session cache obtains
+// first session ticket or warm-up period after first full handshake
+// expires. It returns nil if warm-up is not applicable.
+func (cf *TLSConnFactory) WarmedUp() <-chan struct{} {
+	if cf.sessionCache == nil || cf.warmup <= 0 {
+		return nil
+	}
+	return cf.sessionCache.ready
+}
+
+// Reload reads client certificate, key and CA file again. New material is
+// used only for new handshakes. Previous material is kept if new one
+// fails to load. If onlyModified is set, files are reloaded only if they
+// were changed since last load.
+func (cf *TLSConnFactory) Reload(onlyModified bool) error {
+	err := cf.material.reload(onlyModified)
+	cf.checkClientCert()
+	return err
+}
+
+func (cf *TLSConnFactory) Stats() TLSStats {
+	return TLSStats{
+		FullHandshakes:     cf.fullCount.Load(),
+		ResumedHandshakes:  cf.resumedCount.Load(),
+		ClientCertNotAfter: cf.clientExpiry.expiry(),
+		ServerCertNotAfter: cf.serverExpiry.expiry(),
+	}
+}
+
+func (cf *TLSConnFactory) handshake(ctx context.Context) (net.Conn, tls.ConnectionState, error) {
+	netConn, err := cf.dialer(ctx, "tcp", cf.addr)
+	if err != nil {
+		return nil, tls.ConnectionState{}, newConnectError(cf.addr, err)
+	}
+	hsCtx := ctx
+	if cf.hsTimeout > 0 {
+		var cancel context.CancelFunc
+		hsCtx, cancel = context.WithTimeout(ctx, cf.hsTimeout)
+		defer cancel()
+	}
+	if cf.startTLS != StartTLSNone {
+		if err := startTLS(hsCtx, cf.startTLS, netConn, cf.tlsConfig.ServerName); err != nil {
+			netConn.Close()
+			return nil, tls.ConnectionState{}, newStartTLSError(cf.addr, err)
+		}
+	}
+	if cf.utlsConfig != nil {
+		conn, cs, err := cf.utlsHandshake(hsCtx, netConn)
+		if err != nil {
+			netConn.Close()
+			return nil, cs, newHandshakeError(cf.addr, err)
 		}
+		return conn, cs, nil
+	}
+	tlsConn := tls.Client(netConn, cf.configForDial())
+	err = tlsConn.HandshakeContext(hsCtx)
+	if err != nil {
+		netConn.Close()
+		return nil, tls.ConnectionState{}, newHandshakeError(cf.addr, err)
 	}
-	return &TLSConnFactory{
-		addr:      net.JoinHostPort(host, strconv.Itoa(int(port))),
-		tlsConfig: &tlsConfig,
-		dialer:    dialer,
-		sem:       semaphore.NewWeighted(int64(dialers)),
-	}, nil
+	return tlsConn, tlsConn.ConnectionState(), nil
 }
 
 func (cf *TLSConnFactory) DialContext(ctx context.Context) (net.Conn, error) {
@@ -99,15 +310,42 @@ func (cf *TLSConnFactory) DialContext(ctx context.Context) (net.Conn, error) {
 		return nil, errors.New("Context was cancelled")
 	}
 	defer cf.sem.Release(1)
-	netConn, err := cf.dialer(ctx, "tcp", cf.addr)
-	if err != nil {
-		return nil, fmt.Errorf("cf.dialer.DialContext(ctx, \"tcp\", %q) failed: %v", cf.addr, err)
+	cf.checkClientCert()
+	tlsConn, cs, err := cf.handshake(ctx)
+	var echErr *tls.ECHRejectionError
+	if errors.As(err, &echErr) && len(echErr.RetryConfigList) > 0 {
+		cf.logger.Info("Server %s rejected ECH and provided retry configs. Retrying with them.", cf.addr)
+		retryConfig := echErr.RetryConfigList
+		cf.echConfig.Store(&retryConfig)
+		tlsConn, cs, err = cf.handshake(ctx)
 	}
-	tlsConn := tls.Client(netConn, cf.tlsConfig)
-	err = tlsConn.HandshakeContext(ctx)
 	if err != nil {
-		netConn.Close()
-		return nil, fmt.Errorf("tlsConn.HandshakeContext(ctx) failed: %v", err)
+		return nil, err
+	}
+	if len(cs.PeerCertificates) > 0 {
+		cf.serverExpiry.check(cs.PeerCertificates[0])
+	}
+	cf.logger.Debug("TLS connection to %s: version=%s cipher=%s alpn=%q ech=%t",
+		cf.addr, tls.VersionName(cs.Version), tls.CipherSuiteName(cs.CipherSuite), cs.NegotiatedProtocol,
+		cs.ECHAccepted)
+	if cs.DidResume {
+		cf.resumedCount.Add(1)
+		cf.logger.Debug("TLS session to %s resumed", cf.addr)
+		if cf.sessionCache != nil {
+			cf.sessionCache.markReady()
+		}
+	} else {
+		cf.fullCount.Add(1)
+		cf.logger.Debug("Full TLS handshake with %s completed", cf.addr)
+		if warmed := cf.WarmedUp(); warmed != nil {
+			select {
+			case <-warmed:
+			default:
+				// Server may not issue tickets at all. Don't hold
+				// pool warm-up forever in that case.
+				time.AfterFunc(cf.warmup, cf.sessionCache.markReady)
+			}
+		}
 	}
 	return tlsConn, nil
 }
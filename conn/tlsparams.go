@@ -0,0 +1,88 @@
+package conn
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+func ParseTLSVersion(s string) (uint16, error) {
+	v, ok := tlsVersions[strings.TrimPrefix(strings.ToUpper(s), "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", s)
+	}
+	return v, nil
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+// ParseCipherSuites accepts comma-separated list of TLS 1.0-1.2 cipher
+// suite names as reported by tls.CipherSuiteName. TLS 1.3 suites aren't
+// configurable and insecure suites are refused.
+func ParseCipherSuites(s string) ([]uint16, error) {
+	known := make(map[string]*tls.CipherSuite)
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		known[cs.Name] = cs
+	}
+	var res []uint16
+	for _, name := range splitList(s) {
+		cs, ok := known[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		if cs.Insecure {
+			return nil, fmt.Errorf("cipher suite %q is insecure", name)
+		}
+		if !slices.ContainsFunc(cs.SupportedVersions, func(v uint16) bool { return v < tls.VersionTLS13 }) {
+			return nil, fmt.Errorf("cipher suite %q is TLS 1.3 only and isn't configurable", name)
+		}
+		res = append(res, cs.ID)
+	}
+	return res, nil
+}
+
+// ParseCurves accepts comma-separated list of key exchange curve names:
+// X25519, P256, P384, P521.
+func ParseCurves(s string) ([]tls.CurveID, error) {
+	var res []tls.CurveID
+	for _, name := range splitList(s) {
+		normalized := strings.ReplaceAll(strings.TrimPrefix(strings.ToUpper(name), "CURVE"), "-", "")
+		id, ok := tlsCurves[normalized]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		res = append(res, id)
+	}
+	return res, nil
+}
+
+func ParseALPN(s string) []string {
+	return splitList(s)
+}
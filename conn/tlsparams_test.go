@@ -0,0 +1,35 @@
+package conn
+
+import (
+	"crypto/tls"
+	"slices"
+	"testing"
+)
+
+func TestParseCipherSuites(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    []uint16
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, false},
+		{"tls_ecdhe_ecdsa_with_chacha20_poly1305_sha256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		}, false},
+		{"TLS_AES_128_GCM_SHA256", nil, true},
+		{"TLS_CHACHA20_POLY1305_SHA256", nil, true},
+		{"TLS_RSA_WITH_RC4_128_SHA", nil, true},
+		{"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256", nil, true},
+		{"TLS_NO_SUCH_SUITE", nil, true},
+	} {
+		got, err := ParseCipherSuites(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("ParseCipherSuites(%q): unexpected error: %v", tc.in, err)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Fatalf("ParseCipherSuites(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
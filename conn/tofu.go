@@ -0,0 +1,98 @@
+package conn
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	clog "github.com/Snawoot/steady-tun/log"
+)
+
+// KnownHosts is a trust-on-first-use store of server certificate
+// fingerprints. File format is similar to SSH known_hosts: one
+// "host:port SHA256:<base64 fingerprint>" entry per line.
+type KnownHosts struct {
+	path   string
+	logger *clog.CondLogger
+	mux    sync.Mutex
+	hosts  map[string]string
+}
+
+func NewKnownHosts(path string, logger *clog.CondLogger) (*KnownHosts, error) {
+	kh := &KnownHosts{
+		path:   path,
+		logger: logger,
+		hosts:  make(map[string]string),
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return kh, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: malformed known hosts entry", path, lineno)
+		}
+		kh.hosts[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return kh, nil
+}
+
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+func (kh *KnownHosts) Verify(hostport string, cert *x509.Certificate) error {
+	presented := CertFingerprint(cert)
+	kh.mux.Lock()
+	defer kh.mux.Unlock()
+	known, ok := kh.hosts[hostport]
+	if !ok {
+		if err := kh.append(hostport, presented); err != nil {
+			return fmt.Errorf("unable to record fingerprint for %s: %w", hostport, err)
+		}
+		kh.hosts[hostport] = presented
+		kh.logger.Warning("Permanently added %s with certificate fingerprint %s to known hosts %q",
+			hostport, presented, kh.path)
+		return nil
+	}
+	if known != presented {
+		kh.logger.Critical("CERTIFICATE OF %s HAS CHANGED! IT IS POSSIBLE THAT SOMEONE IS DOING SOMETHING NASTY! "+
+			"Known fingerprint: %s, presented fingerprint: %s. "+
+			"Remove entry from %q if this change is expected.", hostport, known, presented, kh.path)
+		return fmt.Errorf("certificate fingerprint of %s changed: known %s, presented %s", hostport, known, presented)
+	}
+	return nil
+}
+
+func (kh *KnownHosts) append(hostport, fingerprint string) error {
+	f, err := os.OpenFile(kh.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s %s\n", hostport, fingerprint); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,67 @@
+package conn
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKnownHosts(t *testing.T) {
+	pki := newTestPKI(t)
+	other := newTestPKI(t)
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	kh, err := NewKnownHosts(path, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		hostport string
+		cert     *testPKI
+		wantErr  bool
+	}{
+		{"first use", "server.example.com:443", pki, false},
+		{"match", "server.example.com:443", pki, false},
+		{"changed", "server.example.com:443", other, true},
+		{"other port first use", "server.example.com:8443", other, false},
+		{"still known", "server.example.com:443", pki, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := kh.Verify(tc.hostport, tc.cert.leaf)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 {
+		t.Fatalf("expected 2 recorded entries, got %q", data)
+	}
+
+	reloaded, err := NewKnownHosts(path, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Verify("server.example.com:443", pki.leaf); err != nil {
+		t.Fatalf("recorded fingerprint not accepted after reload: %v", err)
+	}
+	if err := reloaded.Verify("server.example.com:443", other.leaf); err == nil {
+		t.Fatal("changed fingerprint accepted after reload")
+	}
+}
+
+func TestKnownHostsMalformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, []byte("server.example.com:443\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewKnownHosts(path, testLogger()); err == nil {
+		t.Fatal("malformed entry accepted")
+	}
+}
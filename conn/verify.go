@@ -0,0 +1,129 @@
+package conn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// verifier performs server certificate checks which can't be expressed
+// with standard tls.Config verification.
+type verifier struct {
+	material   *materialLoader
+	serverName string
+	pins       pinSet
+	pinMode    PinMode
+	knownHosts *KnownHosts
+	hostport   string
+	identity   *ServerIdentity
+	revocation *revocationChecker
+	dane       *daneVerifier
+}
+
+func (v *verifier) verifyConnection(cs tls.ConnectionState) error {
+	return v.verifyPeer(cs.PeerCertificates, cs.OCSPResponse)
+}
+
+func (v *verifier) verifyPeer(certs []*x509.Certificate, staple []byte) error {
+	if len(certs) == 0 {
+		return errors.New("tls: server presented no certificates")
+	}
+	err := v.verifyCerts(certs, staple)
+	if errors.Is(err, errTLSALookup) {
+		return err
+	}
+	if err != nil {
+		return &tls.CertificateVerificationError{
+			UnverifiedCertificates: certs,
+			Err:                    err,
+		}
+	}
+	return nil
+}
+
+func (v *verifier) verifyCerts(certs []*x509.Certificate, staple []byte) error {
+	chain, err := v.verifyTrust(certs)
+	if err != nil {
+		return err
+	}
+	if v.revocation != nil {
+		if err := v.revocation.check(chain, staple, v.material.get().crls); err != nil {
+			return err
+		}
+	}
+	if !v.identity.Empty() {
+		return v.identity.verify(certs[0])
+	}
+	return nil
+}
+
+// verifyTrust checks if server certificate is trusted and returns
+// certificate chain starting from leaf.
+func (v *verifier) verifyTrust(certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	if v.knownHosts != nil {
+		return certs, v.knownHosts.Verify(v.hostport, certs[0])
+	}
+	if len(v.pins) > 0 && v.pinMode == PinOnly {
+		return certs, v.verifyPinnedChain(certs)
+	}
+	if v.dane != nil && v.dane.mode == DANEOnly {
+		_, err := v.dane.verify(certs, v.verifyChain)
+		return certs, err
+	}
+	chains, err := v.verifyChain(certs, v.material.get().roots)
+	if err != nil {
+		return nil, err
+	}
+	if v.dane != nil {
+		if _, err := v.dane.verify(certs, v.verifyChain); err != nil {
+			return nil, err
+		}
+	}
+	if len(v.pins) > 0 {
+		for _, chain := range chains {
+			for _, cert := range chain {
+				if v.pins.match(cert) {
+					return chain, nil
+				}
+			}
+		}
+		return nil, errors.New("no pinned public key in verified certificate chain")
+	}
+	return chains[0], nil
+}
+
+func (v *verifier) verifyChain(certs []*x509.Certificate, roots *x509.CertPool) ([][]*x509.Certificate, error) {
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       v.serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	return certs[0].Verify(opts)
+}
+
+// verifyPinnedChain accepts certificate chain if leaf key is pinned or
+// leaf is signed through presented certificate with pinned key.
+func (v *verifier) verifyPinnedChain(certs []*x509.Certificate) error {
+	if v.pins.match(certs[0]) {
+		if v.serverName != "" {
+			return certs[0].VerifyHostname(v.serverName)
+		}
+		return nil
+	}
+	anchors := x509.NewCertPool()
+	found := false
+	for _, cert := range certs[1:] {
+		if v.pins.match(cert) {
+			anchors.AddCert(cert)
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("no pinned public key in presented certificate chain")
+	}
+	_, err := v.verifyChain(certs, anchors)
+	return err
+}
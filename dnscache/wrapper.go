@@ -2,12 +2,14 @@ package dnscache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
 	"time"
 
 	"github.com/jellydator/ttlcache/v3"
+	"golang.org/x/sync/singleflight"
 )
 
 type ContextDialer = func(ctx context.Context, network, address string) (net.Conn, error)
@@ -29,27 +31,63 @@ type Resolver interface {
 func WrapDialer(dialer ContextDialer, resolver Resolver, size int, posTTL, negTTL, timeout time.Duration) ContextDialer {
 	cache := ttlcache.New[cacheKey, cacheValue](
 		ttlcache.WithDisableTouchOnHit[cacheKey, cacheValue](),
-		ttlcache.WithLoader(
-			ttlcache.NewSuppressedLoader(
-				ttlcache.LoaderFunc[cacheKey, cacheValue](
-					func(c *ttlcache.Cache[cacheKey, cacheValue], key cacheKey) *ttlcache.Item[cacheKey, cacheValue] {
-						ctx, cl := context.WithTimeout(context.Background(), timeout)
-						defer cl()
-						res, err := resolver.LookupNetIP(ctx, key.network, key.host)
-						setTTL := negTTL
-						if err == nil {
-							setTTL = posTTL
-						}
-						return c.Set(key, cacheValue{
-							addrs: res,
-							err:   err,
-						}, setTTL)
-					},
-				),
-				nil),
-		),
 		ttlcache.WithCapacity[cacheKey, cacheValue](uint64(size)),
 	)
+	var group singleflight.Group
+	return resolvingDialer(dialer, func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		key := cacheKey{
+			network: network,
+			host:    host,
+		}
+		if resItem := cache.Get(key); resItem != nil {
+			res := resItem.Value()
+			return res.addrs, res.err
+		}
+		// Lookup is shared by concurrent dials, so it isn't bound to
+		// context of any of them. Dial stops waiting when its context
+		// is done.
+		resCh := group.DoChan(network+" "+host, func() (any, error) {
+			lookupCtx, cl := context.WithTimeout(context.Background(), timeout)
+			defer cl()
+			addrs, err := resolver.LookupNetIP(lookupCtx, network, host)
+			setTTL := negTTL
+			if err == nil {
+				setTTL = posTTL
+			}
+			cache.Set(key, cacheValue{
+				addrs: addrs,
+				err:   err,
+			}, setTTL)
+			return addrs, err
+		})
+		select {
+		case res := <-resCh:
+			if res.Err != nil {
+				return nil, res.Err
+			}
+			return res.Val.([]netip.Addr), nil
+		case <-ctx.Done():
+			return nil, &net.DNSError{
+				Err:       ctx.Err().Error(),
+				Name:      host,
+				IsTimeout: errors.Is(ctx.Err(), context.DeadlineExceeded),
+				UnwrapErr: ctx.Err(),
+			}
+		}
+	})
+}
+
+// WrapDialerNoCache resolves destination address with resolver on every
+// dial, applying separate timeout for name resolution.
+func WrapDialerNoCache(dialer ContextDialer, resolver Resolver, timeout time.Duration) ContextDialer {
+	return resolvingDialer(dialer, func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		ctx, cl := context.WithTimeout(ctx, timeout)
+		defer cl()
+		return resolver.LookupNetIP(ctx, network, host)
+	})
+}
+
+func resolvingDialer(dialer ContextDialer, lookup func(ctx context.Context, network, host string) ([]netip.Addr, error)) ContextDialer {
 	wrapped := func(ctx context.Context, network, address string) (net.Conn, error) {
 		var resolveNetwork string
 		switch network {
@@ -68,22 +106,14 @@ func WrapDialer(dialer ContextDialer, resolver Resolver, size int, posTTL, negTT
 			return nil, fmt.Errorf("failed to extract host and port from %s: %w", address, err)
 		}
 
-		resItem := cache.Get(cacheKey{
-			network: resolveNetwork,
-			host: host,
-		})
-		if resItem == nil {
-			return nil, fmt.Errorf("cache lookup failed for pair <%q, %q>", resolveNetwork, host)
-		}
-
-		res := resItem.Value()
-		if res.err != nil {
-			return nil, res.err
+		addrs, err := lookup(ctx, resolveNetwork, host)
+		if err != nil {
+			return nil, err
 		}
 
 		var conn net.Conn
 
-		for _, ip := range res.addrs {
+		for _, ip := range addrs {
 			conn, err = dialer(ctx, network, net.JoinHostPort(ip.String(), port))
 			if err == nil {
 				return conn, nil
@@ -0,0 +1,83 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testResolver func(ctx context.Context, network, host string) ([]netip.Addr, error)
+
+func (r testResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	return r(ctx, network, host)
+}
+
+func failingDialer(ctx context.Context, network, address string) (net.Conn, error) {
+	return nil, errors.New("dial not expected")
+}
+
+func TestWrapDialerNoCacheContext(t *testing.T) {
+	resolver := testResolver(func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		<-ctx.Done()
+		return nil, &net.DNSError{Err: ctx.Err().Error(), Name: host, IsTimeout: true}
+	})
+	dialer := WrapDialerNoCache(failingDialer, resolver, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := dialer(ctx, "tcp", "example.com:443")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("lookup ignored dial context, took %v", elapsed)
+	}
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		t.Fatalf("expected *net.DNSError, got %v", err)
+	}
+}
+
+func TestWrapDialerContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	resolver := testResolver(func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil, &net.DNSError{Err: "timeout", Name: host, IsTimeout: true}
+	})
+	dialer := WrapDialer(failingDialer, resolver, 16, time.Minute, time.Minute, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := dialer(ctx, "tcp", "example.com:443")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("lookup ignored dial context, took %v", elapsed)
+	}
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected *net.DNSError with deadline exceeded, got %v", err)
+	}
+}
+
+func TestWrapDialerDNSError(t *testing.T) {
+	var lookups atomic.Int64
+	resolver := testResolver(func(ctx context.Context, network, host string) ([]netip.Addr, error) {
+		lookups.Add(1)
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	})
+	dialer := WrapDialer(failingDialer, resolver, 16, time.Minute, time.Minute, time.Second)
+	for i := 0; i < 2; i++ {
+		_, err := dialer(context.Background(), "tcp", "example.com:443")
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Fatalf("attempt %d: expected not found *net.DNSError, got %v", i, err)
+		}
+	}
+	if n := lookups.Load(); n != 1 {
+		t.Fatalf("%d lookups, expected negative result to be cached", n)
+	}
+}
@@ -1,16 +1,28 @@
 module github.com/Snawoot/steady-tun
 
-go 1.23
+go 1.23.0
 
 toolchain go1.23.2
 
 require (
 	github.com/huandu/skiplist v1.2.1
 	github.com/jellydator/ttlcache/v3 v3.3.0
+	github.com/miekg/dns v1.1.63
+	github.com/refraction-networking/utls v1.6.7
+	github.com/youmark/pkcs8 v0.0.0-20240726163527-a2c0da244d78
+	golang.org/x/crypto v0.36.0
+	golang.org/x/net v0.31.0
 	golang.org/x/sync v0.8.0
+	software.sslmate.com/src/go-pkcs12 v0.5.0
 )
 
 require (
+	github.com/andybalholm/brotli v1.0.6 // indirect
+	github.com/cloudflare/circl v1.3.7 // indirect
 	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
+	github.com/klauspost/compress v1.17.4 // indirect
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
+	golang.org/x/mod v0.18.0 // indirect
+	golang.org/x/sys v0.31.0 // indirect
+	golang.org/x/tools v0.22.0 // indirect
 )
@@ -1,21 +1,32 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"os/signal"
 	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"golang.org/x/sync/semaphore"
+
 	conn "github.com/Snawoot/steady-tun/conn"
 	"github.com/Snawoot/steady-tun/dnscache"
 	clog "github.com/Snawoot/steady-tun/log"
 	"github.com/Snawoot/steady-tun/pool"
+	"github.com/Snawoot/steady-tun/proxyproto"
 	"github.com/Snawoot/steady-tun/server"
 )
 
@@ -35,23 +46,139 @@ func arg_fail(msg string) {
 	os.Exit(2)
 }
 
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 type CLIArgs struct {
 	host                  string
 	port                  uint
 	verbosity             int
 	bind_address          string
 	bind_port             uint
+	bindUnix              string
+	identities            []*clientIdentity
 	pool_size             uint
 	dialers               uint
 	backoff, ttl, timeout time.Duration
-	cert, key, cafile     string
+	permBackoff           time.Duration
+	dnsTimeout            time.Duration
+	tlsHandshakeTimeout   time.Duration
+	cert, key             string
+	pkcs12File            string
+	keyPasswordFile       string
+	keyPasswordEnv        string
+	keyPassword           string
+	cafiles, cadirs       stringList
+	caSystem              bool
 	hostname_check        bool
 	tls_servername        string
+	pins                  stringList
+	pinMode               conn.PinMode
+	knownHosts            string
+	sanDNS, sanIP, sanURI stringList
+	sanEmail              stringList
+	serverIdentity        *conn.ServerIdentity
+	ocspMode              conn.RevocationMode
+	crlFiles              stringList
+	crlMode               conn.RevocationMode
+	daneMode              conn.DANEMode
+	daneResolver          string
+	echConfigFile         string
+	echDNS                bool
+	echConfigList         []byte
+	tlsMinVersion         uint16
+	tlsMaxVersion         uint16
+	tlsCiphers            []uint16
+	tlsCurves             []tls.CurveID
+	tlsALPN               []string
+	tlsFingerprint        conn.Fingerprint
+	startTLS              conn.StartTLS
+	preamble              conn.Preamble
+	preambleTimeout       time.Duration
+	proxyHeader           proxyproto.Version
+	proxyTLVs             bool
+	acceptProxy           bool
+	upstreamProxy         *url.URL
+	upstreamProxyHeader   http.Header
+	proxyTrusted          []netip.Prefix
+	acceptProxyTimeout    time.Duration
+	tlsReloadInterval     time.Duration
+	expiryThresholds      []time.Duration
 	tlsSessionCache       bool
+	tlsSessionWarmup      time.Duration
+	tlsSessionFile        string
+	tlsKeyLogFile         string
 	tlsEnabled            bool
 	dnsCacheTTL           time.Duration
 	dnsNegCacheTTL        time.Duration
 	showVersion           bool
+	statsInterval         time.Duration
+}
+
+type clientIdentity struct {
+	name              string
+	cert, key, pkcs12 string
+	proxyHeader       *proxyproto.Version
+	poolSize          uint
+	rule              server.IdentityRule
+}
+
+// parseClientIdentity parses identity rule parameters along with name,
+// cert, key and pkcs12 parameters specifying client certificate and pool
+// size.
+func parseClientIdentity(s string) (*clientIdentity, error) {
+	id := new(clientIdentity)
+	var ruleParams []string
+	for _, kv := range strings.Split(s, ",") {
+		key, value, _ := strings.Cut(kv, "=")
+		switch key {
+		case "name":
+			id.name = value
+		case "cert":
+			id.cert = value
+		case "key":
+			id.key = value
+		case "pkcs12":
+			id.pkcs12 = value
+		case "proxy":
+			version, err := proxyproto.ParseVersion(value)
+			if err != nil {
+				return nil, err
+			}
+			id.proxyHeader = &version
+		case "pool":
+			size, err := strconv.ParseUint(value, 10, 0)
+			if err != nil {
+				return nil, fmt.Errorf("bad pool size %q: %w", value, err)
+			}
+			if size < 1 {
+				return nil, errors.New("identity pool size should be not less than 1")
+			}
+			id.poolSize = uint(size)
+		default:
+			ruleParams = append(ruleParams, kv)
+		}
+	}
+	if id.cert == "" && id.pkcs12 == "" {
+		return nil, errors.New("client identity requires either cert and key or pkcs12 parameters")
+	}
+	rule, err := server.ParseIdentityRule(strings.Join(ruleParams, ","))
+	if err != nil {
+		return nil, err
+	}
+	id.rule = rule
+	if id.name == "" {
+		id.name = strings.Join(ruleParams, ",")
+	}
+	return id, nil
 }
 
 func parse_args() CLIArgs {
@@ -62,21 +189,204 @@ func parse_args() CLIArgs {
 		"(10 - debug, 20 - info, 30 - warning, 40 - error, 50 - critical)")
 	flag.StringVar(&args.bind_address, "bind-address", "127.0.0.1", "bind address")
 	flag.UintVar(&args.bind_port, "bind-port", 57800, "bind port")
+	flag.StringVar(&args.bindUnix, "bind-unix", "", "also accept connections on unix socket at specified path")
+	flag.Func("client-identity", "use separate pool with own client certificate for matching local clients. "+
+		"Value is comma-separated list of rule parameters src=CIDR, ports=N-M, uid=N (unix socket peer), "+
+		"listener=ADDRESS (bind address:port or unix socket path) and certificate parameters cert=FILE, key=FILE "+
+		"or pkcs12=FILE, optionally name=NAME, proxy=VERSION overriding -proxy-protocol and pool=N overriding -pool-size. "+
+		"First matching identity is used. Can be repeated",
+		func(value string) error {
+			id, err := parseClientIdentity(value)
+			if err != nil {
+				return err
+			}
+			args.identities = append(args.identities, id)
+			return nil
+		})
 	flag.UintVar(&args.pool_size, "pool-size", 50, "connection pool size")
-	flag.UintVar(&args.dialers, "dialers", uint(4*runtime.GOMAXPROCS(0)), "concurrency limit for TLS connection attempts")
+	flag.UintVar(&args.dialers, "dialers", uint(4*runtime.GOMAXPROCS(0)), "concurrency limit for TLS connection attempts. "+
+		"Shared by all client identities")
 	flag.DurationVar(&args.backoff, "backoff", 5*time.Second, "delay between connection attempts")
+	flag.DurationVar(&args.permBackoff, "config-error-backoff", 5*time.Minute, "delay between connection attempts "+
+		"after certificate or TLS configuration error")
 	flag.DurationVar(&args.ttl, "ttl", 30*time.Second, "lifetime of idle pool connection in seconds")
-	flag.DurationVar(&args.timeout, "timeout", 4*time.Second, "server connect timeout")
+	flag.DurationVar(&args.timeout, "timeout", 4*time.Second, "server TCP connect timeout")
+	flag.DurationVar(&args.dnsTimeout, "dns-timeout", 4*time.Second, "DNS resolution timeout")
+	flag.DurationVar(&args.tlsHandshakeTimeout, "tls-handshake-timeout", 10*time.Second, "TLS handshake timeout (0 - no timeout)")
 	flag.StringVar(&args.cert, "cert", "", "use certificate for client TLS auth")
 	flag.StringVar(&args.key, "key", "", "key for TLS certificate")
-	flag.StringVar(&args.cafile, "cafile", "", "override default CA certs by specified in file")
+	flag.StringVar(&args.pkcs12File, "pkcs12", "", "use PKCS#12 bundle with client certificate, key and chain "+
+		"for client TLS auth")
+	flag.StringVar(&args.keyPasswordFile, "key-password-file", "", "read password for PKCS#12 bundle "+
+		"or encrypted key from file")
+	flag.StringVar(&args.keyPasswordEnv, "key-password-env", "", "read password for PKCS#12 bundle "+
+		"or encrypted key from specified environment variable")
+	flag.Var(&args.cafiles, "cafile", "override default CA certs by specified in file. Can be repeated")
+	flag.Var(&args.cadirs, "cadir", "override default CA certs by ones found in directory. Can be repeated")
+	flag.BoolVar(&args.caSystem, "ca-system", false, "trust system CA certs in addition to ones specified by -cafile and -cadir")
 	flag.BoolVar(&args.hostname_check, "hostname-check", true, "check hostname in server cert subject")
 	flag.StringVar(&args.tls_servername, "tls-servername", "", "specifies hostname to expect in server cert")
+	flag.Var(&args.pins, "pin-sha256", "base64-encoded SHA-256 hash of upstream certificate public key (SPKI). "+
+		"Can be repeated to specify backup pins")
+	flag.Func("pin-mode", "pin check mode: "+
+		"\"ca\" - check pins in addition to CA validation (default), \"only\" - check pins instead of CA validation",
+		func(value string) (err error) {
+			args.pinMode, err = conn.ParsePinMode(value)
+			return
+		})
+	flag.StringVar(&args.knownHosts, "known-hosts", "", "enable trust-on-first-use server verification "+
+		"instead of CA validation, recording certificate fingerprints in specified file")
+	flag.Func("tls-min-version", "minimal TLS version: 1.0, 1.1, 1.2 or 1.3 (default 1.2)", func(value string) (err error) {
+		args.tlsMinVersion, err = conn.ParseTLSVersion(value)
+		return
+	})
+	flag.Func("tls-max-version", "maximal TLS version: 1.0, 1.1, 1.2 or 1.3 (default 1.3)", func(value string) (err error) {
+		args.tlsMaxVersion, err = conn.ParseTLSVersion(value)
+		return
+	})
+	flag.Func("tls-ciphers", "comma-separated list of TLS 1.0-1.2 cipher suites "+
+		"(e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256). TLS 1.3 suites are not configurable, insecure suites are refused", func(value string) (err error) {
+		args.tlsCiphers, err = conn.ParseCipherSuites(value)
+		return
+	})
+	flag.Func("tls-curves", "comma-separated list of key exchange curves in order of preference: "+
+		"X25519, P256, P384, P521", func(value string) (err error) {
+		args.tlsCurves, err = conn.ParseCurves(value)
+		return
+	})
+	flag.Func("tls-alpn", "comma-separated list of ALPN protocols to advertise", func(value string) error {
+		args.tlsALPN = conn.ParseALPN(value)
+		return nil
+	})
+	flag.Func("tls-fingerprint", "mimic ClientHello of browser: \"chrome\", \"firefox\", \"safari\", "+
+		"\"edge\", \"ios\" or \"randomized\" (default \"go\" - native crypto/tls). Browser profiles advertise "+
+		"ALPN only if -tls-alpn is given and disable TLS session cache", func(value string) (err error) {
+		args.tlsFingerprint, err = conn.ParseFingerprint(value)
+		return
+	})
+	flag.Func("starttls", "upgrade connection to TLS using protocol-specific negotiation before handshake: "+
+		"\"smtp\", \"imap\", \"pop3\", \"xmpp\" or \"postgres\" (default \"none\")", func(value string) (err error) {
+		args.startTLS, err = conn.ParseStartTLS(value)
+		return
+	})
+	flag.Func("preamble-file", "run send/expect script from file on each new upstream connection before "+
+		"it enters the pool. Script lines are \"send TEXT\" or \"expect TEXT\", TEXT may contain escapes \\r, \\n, \\t, \\\\ and \\xHH",
+		func(value string) (err error) {
+			args.preamble, err = conn.LoadPreamble(value)
+			return
+		})
+	flag.DurationVar(&args.preambleTimeout, "preamble-timeout", 10*time.Second, "timeout for preamble script")
+	flag.Func("proxy-protocol", "send PROXY protocol header with client address to upstream: \"v1\", \"v2\" "+
+		"(default \"none\")", func(value string) (err error) {
+		args.proxyHeader, err = proxyproto.ParseVersion(value)
+		return
+	})
+	flag.Func("upstream-proxy", "connect to server through proxy specified by URL: socks5://[user:password@]host:port, "+
+		"socks5h://... to resolve server name by proxy, http://[user:password@]host:port or https://... for HTTP "+
+		"CONNECT proxy", func(value string) error {
+		u, err := url.Parse(value)
+		if err != nil {
+			return err
+		}
+		switch u.Scheme {
+		case "socks5", "socks5h", "http", "https":
+		default:
+			return fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+		if u.Port() == "" {
+			return errors.New("proxy port is required")
+		}
+		args.upstreamProxy = u
+		return nil
+	})
+	flag.Func("upstream-proxy-header", "add header \"Name: value\" to HTTP CONNECT request, "+
+		"e.g. for custom proxy authentication. Can be repeated", func(value string) error {
+		name, val, ok := strings.Cut(value, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return errors.New("header must be in \"Name: value\" format")
+		}
+		if args.upstreamProxyHeader == nil {
+			args.upstreamProxyHeader = make(http.Header)
+		}
+		args.upstreamProxyHeader.Add(strings.TrimSpace(name), strings.TrimSpace(val))
+		return nil
+	})
+	flag.BoolVar(&args.acceptProxy, "accept-proxy-protocol", false, "expect PROXY protocol v1/v2 header on TCP "+
+		"listener from trusted sources and use client address from it")
+	flag.Func("accept-proxy-trusted", "comma-separated list of networks (CIDR) of load balancers allowed to "+
+		"send PROXY protocol header. Can be repeated", func(value string) error {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if !strings.Contains(item, "/") {
+				addr, err := netip.ParseAddr(item)
+				if err != nil {
+					return err
+				}
+				item = netip.PrefixFrom(addr, addr.BitLen()).String()
+			}
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return err
+			}
+			args.proxyTrusted = append(args.proxyTrusted, prefix.Masked())
+		}
+		return nil
+	})
+	flag.DurationVar(&args.acceptProxyTimeout, "accept-proxy-timeout", 5*time.Second,
+		"timeout for receiving PROXY protocol header")
+	flag.BoolVar(&args.proxyTLVs, "proxy-protocol-tlv", false, "add client identity name to PROXY protocol v2 "+
+		"header as TLV of type 0xE0")
+	flag.DurationVar(&args.tlsReloadInterval, "tls-reload-interval", 0, "interval between checks of certificate, key "+
+		"and CA files for changes (0 - reload only on SIGHUP)")
+	args.expiryThresholds = conn.DefaultExpiryThresholds
+	flag.Func("cert-expiry-warn", "comma-separated list of remaining certificate validity periods "+
+		"triggering expiration warning (default "+conn.FormatDurations(conn.DefaultExpiryThresholds)+")",
+		func(value string) (err error) {
+			args.expiryThresholds, err = conn.ParseDurations(value)
+			return
+		})
+	flag.Var(&args.sanDNS, "server-san-dns", "accept only server certificates with specified DNS name in SAN. Can be repeated")
+	flag.Var(&args.sanIP, "server-san-ip", "accept only server certificates with specified IP address in SAN. Can be repeated")
+	flag.Var(&args.sanURI, "server-san-uri", "accept only server certificates with specified URI in SAN, "+
+		"e.g. SPIFFE ID. Can be repeated")
+	flag.Var(&args.sanEmail, "server-san-email", "accept only server certificates with specified email address in SAN. Can be repeated")
+	flag.Func("ocsp-staple", "stapled OCSP response check mode: \"off\" (default), "+
+		"\"soft\" - reject only revoked certificates, \"hard\" - also reject when status is unknown",
+		func(value string) (err error) {
+			args.ocspMode, err = conn.ParseRevocationMode(value)
+			return
+		})
+	flag.Var(&args.crlFiles, "crlfile", "check server certificate against CRL from specified file. Can be repeated")
+	flag.Func("crl-mode", "CRL check mode: \"soft\" (default) - reject only revoked certificates, "+
+		"\"hard\" - also reject certificate if there is no valid CRL for its issuer",
+		func(value string) (err error) {
+			args.crlMode, err = conn.ParseRevocationMode(value)
+			return
+		})
+	flag.Func("dane", "DANE TLSA check mode: \"off\" (default), \"ca\" - in addition to CA validation "+
+		"if TLSA records are published, \"only\" - instead of CA validation", func(value string) (err error) {
+		args.daneMode, err = conn.ParseDANEMode(value)
+		return
+	})
+	flag.StringVar(&args.daneResolver, "dane-resolver", "", "DNSSEC-validating resolver address for TLSA and "+
+		"HTTPS record lookups (default is first nameserver from /etc/resolv.conf)")
+	flag.StringVar(&args.echConfigFile, "ech-config-file", "", "enable Encrypted Client Hello with ECHConfigList "+
+		"from file (PEM, base64 or binary)")
+	flag.BoolVar(&args.echDNS, "ech-dns", false, "enable Encrypted Client Hello with ECHConfigList "+
+		"from HTTPS DNS record of destination host")
 	flag.BoolVar(&args.tlsSessionCache, "tls-session-cache", true, "enable TLS session cache")
+	flag.DurationVar(&args.tlsSessionWarmup, "tls-session-warmup", 1*time.Second, "wait up to this long "+
+		"for session ticket after first full handshake before dialing rest of pool (0 - no warm-up)")
+	flag.StringVar(&args.tlsKeyLogFile, "tls-keylog-file", os.Getenv("SSLKEYLOGFILE"), "append TLS secrets "+
+		"in NSS key log format to specified file for traffic decryption. DEBUGGING ONLY! "+
+		"(default is value of SSLKEYLOGFILE environment variable)")
+	flag.StringVar(&args.tlsSessionFile, "tls-session-file", "", "persist TLS session cache in specified file across restarts. "+
+		"Sessions of client identities are kept in files with identity name appended to this path")
 	flag.BoolVar(&args.showVersion, "version", false, "show program version and exit")
 	flag.BoolVar(&args.tlsEnabled, "tls-enabled", true, "enable TLS client for pool connections")
 	flag.DurationVar(&args.dnsCacheTTL, "dns-cache-ttl", 30*time.Second, "DNS cache TTL")
 	flag.DurationVar(&args.dnsNegCacheTTL, "dns-neg-cache-ttl", 1*time.Second, "negative DNS cache TTL")
+	flag.DurationVar(&args.statsInterval, "stats-interval", 0, "interval between periodic stats log messages (0 to disable)")
 	flag.Parse()
 	if args.showVersion {
 		return args
@@ -96,9 +406,91 @@ func parse_args() CLIArgs {
 	if args.dialers < 1 {
 		arg_fail("dialers parameter should be not less than 1")
 	}
+	if (args.daneMode != conn.DANEOff || args.echDNS) && args.daneResolver == "" {
+		resolver, err := conn.DefaultDNSServer()
+		if err != nil {
+			arg_fail("Unable to determine DNS resolver: " + err.Error())
+		}
+		args.daneResolver = resolver
+	}
+	if args.echConfigFile != "" && args.echDNS {
+		arg_fail("-ech-config-file and -ech-dns options are mutually exclusive")
+	}
+	if args.echConfigFile != "" {
+		echConfigList, err := conn.LoadECHConfigList(args.echConfigFile)
+		if err != nil {
+			arg_fail("Unable to load ECH config: " + err.Error())
+		}
+		args.echConfigList = echConfigList
+	}
+	if args.echDNS {
+		ctx, cancel := context.WithTimeout(context.Background(), args.dnsTimeout)
+		echConfigList, err := conn.FetchECHConfigList(ctx, args.daneResolver, args.host)
+		cancel()
+		if err != nil {
+			arg_fail("Unable to fetch ECH config: " + err.Error())
+		}
+		args.echConfigList = echConfigList
+	}
+	if args.acceptProxy && len(args.proxyTrusted) == 0 {
+		arg_fail("-accept-proxy-protocol requires -accept-proxy-trusted networks")
+	}
+	if len(args.identities) > 0 && !args.tlsEnabled {
+		arg_fail("Client identities require TLS to be enabled")
+	}
+	if args.keyPasswordFile != "" && args.keyPasswordEnv != "" {
+		arg_fail("-key-password-file and -key-password-env options are mutually exclusive")
+	}
+	if args.keyPasswordFile != "" {
+		password, err := os.ReadFile(args.keyPasswordFile)
+		if err != nil {
+			arg_fail("Unable to read key password: " + err.Error())
+		}
+		args.keyPassword = strings.TrimRight(string(password), "\r\n")
+	}
+	if args.keyPasswordEnv != "" {
+		password, ok := os.LookupEnv(args.keyPasswordEnv)
+		if !ok {
+			arg_fail("Environment variable " + args.keyPasswordEnv + " is not set")
+		}
+		args.keyPassword = password
+	}
+	serverIdentity, err := conn.ParseServerIdentity(args.sanDNS, args.sanIP, args.sanURI, args.sanEmail)
+	if err != nil {
+		arg_fail(err.Error())
+	}
+	args.serverIdentity = serverIdentity
 	return args
 }
 
+// upstream is pool serving clients of particular identity
+type upstream struct {
+	name        string
+	rule        server.IdentityRule
+	proxyHeader proxyproto.Version
+	poolSize    uint
+	factory     *conn.TLSConnFactory
+	pool        *pool.ConnPool
+}
+
+func logStats(logger *clog.CondLogger, name string, connPool *pool.ConnPool, tlsFactory *conn.TLSConnFactory) {
+	if name != "" {
+		name = "[" + name + "]"
+	}
+	ps := connPool.Stats()
+	logger.Info("pool%s: prepared=%d/%d", name, ps.Prepared, ps.Size)
+	if tlsFactory != nil {
+		ts := tlsFactory.Stats()
+		logger.Info("tls%s: full_handshakes=%d resumed_handshakes=%d", name, ts.FullHandshakes, ts.ResumedHandshakes)
+		if !ts.ClientCertNotAfter.IsZero() {
+			logger.Info("tls%s: client_cert_validity=%v", name, time.Until(ts.ClientCertNotAfter).Truncate(time.Second))
+		}
+		if !ts.ServerCertNotAfter.IsZero() {
+			logger.Info("tls%s: server_cert_validity=%v", name, time.Until(ts.ServerCertNotAfter).Truncate(time.Second))
+		}
+	}
+}
+
 func main() {
 	args := parse_args()
 	if args.showVersion {
@@ -119,58 +511,268 @@ func main() {
 		args.verbosity)
 	poolLogger := clog.NewCondLogger(log.New(logWriter, "POOL    : ", log.LstdFlags|log.Lshortfile),
 		args.verbosity)
+	statsLogger := clog.NewCondLogger(log.New(logWriter, "STATS   : ", log.LstdFlags|log.Lshortfile),
+		args.verbosity)
 
 	var (
 		dialer      conn.ContextDialer
 		connfactory conn.Factory
+		tlsFactory  *conn.TLSConnFactory
+		warmup      <-chan struct{}
+		upstreams   []upstream
 		err         error
 	)
 	dialer = (&net.Dialer{
 		Timeout: args.timeout,
 	}).DialContext
 
-	if args.dnsCacheTTL > 0 {
-		dialer = dnscache.WrapDialer(dialer, net.DefaultResolver, 128, args.dnsCacheTTL, args.dnsNegCacheTTL, args.timeout)
+	remoteDNS := false
+	if args.upstreamProxy != nil {
+		password, _ := args.upstreamProxy.User.Password()
+		switch args.upstreamProxy.Scheme {
+		case "socks5", "socks5h":
+			dialer, err = conn.NewSOCKS5Dialer(args.upstreamProxy.Host, args.upstreamProxy.User.Username(),
+				password, args.timeout, dialer)
+			if err != nil {
+				panic(err)
+			}
+			remoteDNS = args.upstreamProxy.Scheme == "socks5h"
+		case "http", "https":
+			opts := conn.HTTPProxyOptions{
+				Addr:     args.upstreamProxy.Host,
+				Username: args.upstreamProxy.User.Username(),
+				Password: password,
+				Header:   args.upstreamProxyHeader,
+				Timeout:  args.timeout,
+			}
+			if args.upstreamProxy.Scheme == "https" {
+				opts.TLSConfig = new(tls.Config)
+			}
+			dialer = conn.NewHTTPProxyDialer(opts, dialer)
+			remoteDNS = true
+		}
+	}
+
+	switch {
+	case remoteDNS:
+		// Server name is passed to proxy unresolved
+	case args.dnsCacheTTL > 0:
+		dialer = dnscache.WrapDialer(dialer, net.DefaultResolver, 128, args.dnsCacheTTL, args.dnsNegCacheTTL, args.dnsTimeout)
+	default:
+		dialer = dnscache.WrapDialerNoCache(dialer, net.DefaultResolver, args.dnsTimeout)
 	}
 
 	if args.tlsEnabled {
 		var sessionCache tls.ClientSessionCache
-		if args.tlsSessionCache {
-			sessionCache = tls.NewLRUClientSessionCache(2 * int(args.pool_size))
-		}
-		connfactory, err = conn.NewTLSConnFactory(args.host,
-			uint16(args.port),
-			dialer,
-			args.cert,
-			args.key,
-			args.cafile,
-			args.hostname_check,
-			args.tls_servername,
-			args.dialers,
-			sessionCache,
-			connLogger)
+		if args.tlsSessionCache && args.tlsFingerprint == conn.FingerprintGo {
+			if args.tlsSessionFile != "" {
+				persistentCache, err := conn.NewPersistentSessionCache(args.tlsSessionFile,
+					2*int(args.pool_size), connLogger)
+				if err != nil {
+					panic(err)
+				}
+				defer persistentCache.Close()
+				sessionCache = persistentCache
+			} else {
+				sessionCache = tls.NewLRUClientSessionCache(2 * int(args.pool_size))
+			}
+		}
+		var keyLogWriter io.Writer
+		if args.tlsKeyLogFile != "" {
+			keyLogFile, err := os.OpenFile(args.tlsKeyLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+			if err != nil {
+				panic(err)
+			}
+			defer keyLogFile.Close()
+			keyLogWriter = keyLogFile
+			mainLogger.Critical("!!! TLS KEY LOGGING IS ENABLED !!! Secrets of all upstream TLS sessions are written "+
+				"to %q. Anyone with access to this file can decrypt upstream traffic. "+
+				"Use this only for debugging.", args.tlsKeyLogFile)
+		}
+		var knownHosts *conn.KnownHosts
+		if args.knownHosts != "" {
+			knownHosts, err = conn.NewKnownHosts(args.knownHosts, connLogger)
+			if err != nil {
+				panic(err)
+			}
+		}
+		tlsOpts := conn.TLSOptions{
+			CertFile:         args.cert,
+			KeyFile:          args.key,
+			PKCS12File:       args.pkcs12File,
+			KeyPassword:      args.keyPassword,
+			CAFiles:          args.cafiles,
+			CADirs:           args.cadirs,
+			CASystem:         args.caSystem,
+			HostnameCheck:    args.hostname_check,
+			ServerName:       args.tls_servername,
+			DialSemaphore:    semaphore.NewWeighted(int64(args.dialers)),
+			SessionCache:     sessionCache,
+			SessionWarmup:    args.tlsSessionWarmup,
+			HandshakeTimeout: args.tlsHandshakeTimeout,
+			Pins:             args.pins,
+			PinMode:          args.pinMode,
+			KnownHosts:       knownHosts,
+			ServerIdentity:   args.serverIdentity,
+			OCSPMode:         args.ocspMode,
+			CRLFiles:         args.crlFiles,
+			CRLMode:          args.crlMode,
+			DANEMode:         args.daneMode,
+			DNSServer:        args.daneResolver,
+			DNSTimeout:       args.dnsTimeout,
+			ECHConfigList:    args.echConfigList,
+			MinVersion:       args.tlsMinVersion,
+			MaxVersion:       args.tlsMaxVersion,
+			CipherSuites:     args.tlsCiphers,
+			CurvePreferences: args.tlsCurves,
+			NextProtos:       args.tlsALPN,
+			Fingerprint:      args.tlsFingerprint,
+			StartTLS:         args.startTLS,
+			KeyLogWriter:     keyLogWriter,
+			ExpiryThresholds: args.expiryThresholds,
+		}
+		tlsFactory, err = conn.NewTLSConnFactory(args.host, uint16(args.port), dialer, tlsOpts, connLogger)
 		if err != nil {
 			panic(err)
 		}
+		connfactory = tlsFactory
+		warmup = tlsFactory.WarmedUp()
+		for _, identity := range args.identities {
+			opts := tlsOpts
+			opts.CertFile, opts.KeyFile, opts.PKCS12File = identity.cert, identity.key, identity.pkcs12
+			poolSize := args.pool_size
+			if identity.poolSize != 0 {
+				poolSize = identity.poolSize
+			}
+			// Sessions are bound to client certificate
+			opts.SessionCache = nil
+			if args.tlsSessionCache && args.tlsFingerprint == conn.FingerprintGo {
+				if args.tlsSessionFile != "" {
+					sessionFile := args.tlsSessionFile + "." + url.PathEscape(identity.name)
+					persistentCache, err := conn.NewPersistentSessionCache(sessionFile, 2*int(poolSize), connLogger)
+					if err != nil {
+						panic(fmt.Errorf("client identity %q: %w", identity.name, err))
+					}
+					defer persistentCache.Close()
+					opts.SessionCache = persistentCache
+				} else {
+					opts.SessionCache = tls.NewLRUClientSessionCache(2 * int(poolSize))
+				}
+			}
+			factory, err := conn.NewTLSConnFactory(args.host, uint16(args.port), dialer, opts, connLogger)
+			if err != nil {
+				panic(fmt.Errorf("client identity %q: %w", identity.name, err))
+			}
+			u := upstream{
+				name:        identity.name,
+				rule:        identity.rule,
+				proxyHeader: args.proxyHeader,
+				poolSize:    poolSize,
+				factory:     factory,
+			}
+			if identity.proxyHeader != nil {
+				u.proxyHeader = *identity.proxyHeader
+			}
+			upstreams = append(upstreams, u)
+		}
 	} else {
 		connfactory = conn.NewPlainConnFactory(args.host, uint16(args.port), dialer)
 	}
-	connPool := pool.NewConnPool(args.pool_size, args.ttl, args.backoff, connfactory.DialContext, poolLogger)
+	if args.preamble != nil {
+		connfactory = conn.NewPreambleFactory(connfactory, args.host, uint16(args.port), args.preamble, args.preambleTimeout)
+	}
+	connPool := pool.NewConnPool(args.pool_size, args.ttl, args.backoff, args.permBackoff, connfactory.DialContext, warmup, poolLogger)
 	connPool.Start()
 	defer connPool.Stop()
+	var routes []server.Route
+	for i := range upstreams {
+		u := &upstreams[i]
+		var factory conn.Factory = u.factory
+		if args.preamble != nil {
+			factory = conn.NewPreambleFactory(factory, args.host, uint16(args.port), args.preamble,
+				args.preambleTimeout)
+		}
+		u.pool = pool.NewConnPool(u.poolSize, args.ttl, args.backoff, args.permBackoff,
+			factory.DialContext, u.factory.WarmedUp(), poolLogger)
+		u.pool.Start()
+		defer u.pool.Stop()
+		routes = append(routes, server.Route{
+			Name:        u.name,
+			Rule:        u.rule,
+			Pool:        u.pool,
+			ProxyHeader: u.proxyHeader,
+			ProxyTLVs:   args.proxyTLVs,
+		})
+	}
+	defaultRoute := server.Route{
+		Pool:        connPool,
+		ProxyHeader: args.proxyHeader,
+	}
+	handler := server.NewConnHandler(defaultRoute, routes, handlerLogger).Handle
 
+	var proxyOpts *server.ProxyProtocolOptions
+	if args.acceptProxy {
+		proxyOpts = &server.ProxyProtocolOptions{
+			Trusted: args.proxyTrusted,
+			Timeout: args.acceptProxyTimeout,
+		}
+	}
 	listener := server.NewTCPListener(args.bind_address,
 		uint16(args.bind_port),
-		server.NewConnHandler(connPool, handlerLogger).Handle,
+		proxyOpts,
+		handler,
 		listenerLogger)
 	if err := listener.Start(); err != nil {
 		panic(err)
 	}
 	defer listener.Stop()
+	if args.bindUnix != "" {
+		unixListener := server.NewUnixListener(args.bindUnix, handler, listenerLogger)
+		if err := unixListener.Start(); err != nil {
+			panic(err)
+		}
+		defer unixListener.Stop()
+	}
 
 	mainLogger.Info("Listener started.")
+	var statsTicker, reloadTicker <-chan time.Time
+	if args.statsInterval > 0 {
+		ticker := time.NewTicker(args.statsInterval)
+		defer ticker.Stop()
+		statsTicker = ticker.C
+	}
+	if tlsFactory != nil && args.tlsReloadInterval > 0 {
+		ticker := time.NewTicker(args.tlsReloadInterval)
+		defer ticker.Stop()
+		reloadTicker = ticker.C
+	}
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	<-sigs
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	for running := true; running; {
+		select {
+		case <-sigs:
+			running = false
+		case <-hups:
+			if tlsFactory != nil {
+				mainLogger.Info("Got SIGHUP, reloading TLS certificates.")
+				tlsFactory.Reload(false)
+				for _, u := range upstreams {
+					u.factory.Reload(false)
+				}
+			}
+		case <-reloadTicker:
+			tlsFactory.Reload(true)
+			for _, u := range upstreams {
+				u.factory.Reload(true)
+			}
+		case <-statsTicker:
+			logStats(statsLogger, "", connPool, tlsFactory)
+			for _, u := range upstreams {
+				logStats(statsLogger, u.name, u.pool, u.factory)
+			}
+		}
+	}
 	mainLogger.Info("Shutting down...")
 }
@@ -2,8 +2,10 @@ package pool
 
 import (
 	"context"
+	"errors"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Snawoot/steady-tun/clock"
@@ -16,8 +18,11 @@ type ConnFactory = func(context.Context) (net.Conn, error)
 type ConnPool struct {
 	size         uint
 	ttl, backoff time.Duration
+	permBackoff  time.Duration
+	permFailing  atomic.Bool
 	connFactory  ConnFactory
-	prepared     *queue.RAQueue
+	warmup       <-chan struct{}
+	prepared     *queue.RAQueue[chan *watchedConn]
 	qmux         sync.Mutex
 	logger       *clog.CondLogger
 	ctx          context.Context
@@ -25,21 +30,30 @@ type ConnPool struct {
 	shutdown     sync.WaitGroup
 }
 
+type PoolStats struct {
+	Size     uint
+	Prepared int
+}
+
 type watchedConn struct {
 	conn       net.Conn
 	cancel     context.CancelFunc
 	canceldone chan struct{}
 }
 
-func NewConnPool(size uint, ttl, backoff time.Duration,
-	connFactory ConnFactory, logger *clog.CondLogger) *ConnPool {
+// NewConnPool creates connection pool. If warmup channel is not nil, only
+// one worker starts dialing until warmup gets closed.
+func NewConnPool(size uint, ttl, backoff, permBackoff time.Duration,
+	connFactory ConnFactory, warmup <-chan struct{}, logger *clog.CondLogger) *ConnPool {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &ConnPool{
 		size:        size,
 		ttl:         ttl,
 		backoff:     backoff,
+		permBackoff: permBackoff,
 		connFactory: connFactory,
-		prepared:    queue.NewRAQueue(),
+		warmup:      warmup,
+		prepared:    queue.NewRAQueue[chan *watchedConn](),
 		logger:      logger,
 		ctx:         ctx,
 		cancel:      cancel,
@@ -47,24 +61,49 @@ func NewConnPool(size uint, ttl, backoff time.Duration,
 }
 
 func (p *ConnPool) Start() {
+	if p.size == 0 {
+		return
+	}
 	p.shutdown.Add(int(p.size))
-	for i := uint(0); i < p.size; i++ {
-		go p.worker()
+	if p.warmup != nil && p.size > 1 {
+		go func() {
+			select {
+			case <-p.warmup:
+				p.logger.Info("Warm-up done. Starting remaining %d pool workers.", p.size-1)
+			case <-p.ctx.Done():
+			}
+		}()
+	}
+	go p.worker()
+	for i := uint(1); i < p.size; i++ {
+		go p.delayedWorker()
+	}
+}
+
+func (p *ConnPool) delayedWorker() {
+	if p.warmup != nil {
+		select {
+		case <-p.warmup:
+		case <-p.ctx.Done():
+			p.shutdown.Done()
+			return
+		}
 	}
+	p.worker()
 }
 
-func (p *ConnPool) do_backoff() {
+func (p *ConnPool) do_backoff(d time.Duration) {
 	select {
-	case <-clock.AfterWallClock(p.backoff):
+	case <-clock.AfterWallClock(d):
 	case <-p.ctx.Done():
 	}
 }
 
-func (p *ConnPool) kill_prepared(queue_id uint, watched *watchedConn, output_ch chan *watchedConn) {
+func (p *ConnPool) kill_prepared(queue_id *queue.Handle[chan *watchedConn], watched *watchedConn, output_ch chan *watchedConn) {
 	p.qmux.Lock()
-	deleted_elem := p.prepared.Delete(queue_id)
+	_, deleted := p.prepared.Delete(queue_id)
 	p.qmux.Unlock()
-	if deleted_elem == nil {
+	if !deleted {
 		// Someone already grabbed this slot from queue. Dispatch anyway.
 		p.logger.Debug("Dead conn %v was grabbed from queue", watched.conn.LocalAddr())
 		output_ch <- watched
@@ -89,11 +128,25 @@ func (p *ConnPool) worker() {
 			case <-p.ctx.Done():
 				return
 			default:
-				p.logger.Error("Upstream connection error: %v", err)
-				p.do_backoff()
+				if isPermanent(err) {
+					if p.permFailing.CompareAndSwap(false, true) {
+						p.logger.Critical("Upstream rejects connections due to "+
+							"certificate or TLS configuration problem: %v. "+
+							"Retrying every %v.", err, p.permBackoff)
+					} else {
+						p.logger.Debug("Upstream connection error: %v", err)
+					}
+					p.do_backoff(p.permBackoff)
+				} else {
+					p.logger.Error("Upstream connection error: %v", err)
+					p.do_backoff(p.backoff)
+				}
 				continue
 			}
 		}
+		if p.permFailing.CompareAndSwap(true, false) {
+			p.logger.Info("Upstream connections restored.")
+		}
 		localaddr := conn.LocalAddr()
 		p.logger.Debug("Established upstream connection %v", localaddr)
 
@@ -115,7 +168,7 @@ func (p *ConnPool) worker() {
 		case <-readdone:
 			p.logger.Debug("Pool connection %v was disrupted", localaddr)
 			p.kill_prepared(queue_id, watched, output_ch)
-			p.do_backoff()
+			p.do_backoff(p.backoff)
 		// Expired
 		case <-clock.AfterWallClock(p.ttl):
 			p.logger.Debug("Connection %v seem to be expired", localaddr)
@@ -129,24 +182,40 @@ func (p *ConnPool) worker() {
 
 func (p *ConnPool) Get(ctx context.Context) (net.Conn, error) {
 	p.qmux.Lock()
-	free := p.prepared.Pop()
+	free, ok := p.prepared.Pop()
 	p.qmux.Unlock()
-	if free == nil {
+	if !ok {
 		p.logger.Warning("pool shortage! calling factory directly!")
 		return p.connFactory(ctx)
 	} else {
-		watched := <-(free.(chan *watchedConn))
+		watched := <-free
 		watched.cancel()
 		<-watched.canceldone
 		return watched.conn, nil
 	}
 }
 
+func (p *ConnPool) Stats() PoolStats {
+	p.qmux.Lock()
+	defer p.qmux.Unlock()
+	return PoolStats{
+		Size:     p.size,
+		Prepared: p.prepared.Len(),
+	}
+}
+
 func (p *ConnPool) Stop() {
 	p.cancel()
 	p.shutdown.Wait()
 }
 
+func isPermanent(err error) bool {
+	var permErr interface {
+		Permanent() bool
+	}
+	return errors.As(err, &permErr) && permErr.Permanent()
+}
+
 func connReadContext(ctx context.Context, conn net.Conn, p []byte) (n int, err error) {
 	readDone := make(chan struct{})
 	go func() {
@@ -0,0 +1,171 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	clog "github.com/Snawoot/steady-tun/log"
+)
+
+func testLogger() *clog.CondLogger {
+	return clog.NewCondLogger(log.New(io.Discard, "", 0), clog.CRITICAL)
+}
+
+// pipeDialer produces in-memory connections and counts dial attempts.
+type pipeDialer struct {
+	dials   atomic.Int64
+	mux     sync.Mutex
+	servers []net.Conn
+}
+
+func (d *pipeDialer) dial(ctx context.Context) (net.Conn, error) {
+	d.dials.Add(1)
+	client, server := net.Pipe()
+	d.mux.Lock()
+	d.servers = append(d.servers, server)
+	d.mux.Unlock()
+	return client, nil
+}
+
+func (d *pipeDialer) close() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	for _, c := range d.servers {
+		c.Close()
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	closed := make(chan struct{})
+	close(closed)
+	for _, tc := range []struct {
+		name   string
+		warmup chan struct{}
+		gated  bool
+	}{
+		{"no warm-up", nil, false},
+		{"warmed up", closed, false},
+		{"waiting for ticket", make(chan struct{}), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			const size = 4
+			d := new(pipeDialer)
+			defer d.close()
+			p := NewConnPool(size, time.Minute, time.Second, time.Minute, d.dial, tc.warmup, testLogger())
+			p.Start()
+			defer p.Stop()
+			if tc.gated {
+				waitFor(t, "first dial", func() bool { return d.dials.Load() == 1 })
+				time.Sleep(50 * time.Millisecond)
+				if n := d.dials.Load(); n != 1 {
+					t.Fatalf("%d dials before warm-up, expected 1", n)
+				}
+				if n := p.Stats().Prepared; n != 1 {
+					t.Fatalf("%d prepared connections before warm-up, expected 1", n)
+				}
+				close(tc.warmup)
+			}
+			waitFor(t, "full pool", func() bool { return p.Stats().Prepared == size })
+			if n := d.dials.Load(); n != size {
+				t.Fatalf("%d dials, expected %d", n, size)
+			}
+		})
+	}
+}
+
+func TestWarmupStop(t *testing.T) {
+	d := new(pipeDialer)
+	defer d.close()
+	p := NewConnPool(4, time.Minute, time.Second, time.Minute, d.dial, make(chan struct{}), testLogger())
+	p.Start()
+	waitFor(t, "first dial", func() bool { return d.dials.Load() == 1 })
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pool waiting for warm-up didn't stop")
+	}
+}
+
+type dialError struct {
+	permanent bool
+}
+
+func (e *dialError) Error() string {
+	return fmt.Sprintf("dial failed, permanent: %v", e.permanent)
+}
+
+func (e *dialError) Permanent() bool {
+	return e.permanent
+}
+
+func TestIsPermanent(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("plain"), false},
+		{&dialError{false}, false},
+		{&dialError{true}, true},
+		{fmt.Errorf("wrapped: %w", &dialError{true}), true},
+	} {
+		if got := isPermanent(tc.err); got != tc.want {
+			t.Errorf("isPermanent(%v) = %v, expected %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestConfigBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		err       error
+		wantRetry bool
+	}{
+		{"transient error", errors.New("connection refused"), true},
+		{"transient dial error", &dialError{false}, true},
+		{"config error", &dialError{true}, false},
+		{"wrapped config error", fmt.Errorf("wrapped: %w", &dialError{true}), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var dials atomic.Int64
+			dial := func(ctx context.Context) (net.Conn, error) {
+				dials.Add(1)
+				return nil, tc.err
+			}
+			p := NewConnPool(1, time.Minute, 10*time.Millisecond, time.Hour, dial, nil, testLogger())
+			p.Start()
+			defer p.Stop()
+			time.Sleep(200 * time.Millisecond)
+			n := dials.Load()
+			if tc.wantRetry && n < 3 {
+				t.Fatalf("only %d dials with short backoff", n)
+			}
+			if !tc.wantRetry && n != 1 {
+				t.Fatalf("%d dials, expected single attempt before config error backoff", n)
+			}
+		})
+	}
+}
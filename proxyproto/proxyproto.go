@@ -0,0 +1,155 @@
+// Package proxyproto implements HAProxy PROXY protocol headers.
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+type Version int
+
+const (
+	None Version = iota
+	V1
+	V2
+)
+
+func ParseVersion(s string) (Version, error) {
+	switch strings.ToLower(s) {
+	case "none", "off", "":
+		return None, nil
+	case "v1", "1":
+		return V1, nil
+	case "v2", "2":
+		return V2, nil
+	default:
+		return 0, fmt.Errorf("unknown PROXY protocol version %q", s)
+	}
+}
+
+func (v Version) String() string {
+	switch v {
+	case None:
+		return "none"
+	case V1:
+		return "v1"
+	case V2:
+		return "v2"
+	default:
+		return fmt.Sprintf("Version(%d)", int(v))
+	}
+}
+
+// TLV types from PROXY protocol specification
+const (
+	TypeALPN      = 0x01
+	TypeAuthority = 0x02
+	TypeCRC32C    = 0x03
+	TypeNoop      = 0x04
+	TypeUniqueID  = 0x05
+	TypeSSL       = 0x20
+	TypeNetNS     = 0x30
+	// TypeMinCustom starts range of application-specific types
+	TypeMinCustom = 0xE0
+	TypeMaxCustom = 0xEF
+)
+
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Header describes proxied connection. Source and Destination which are
+// not TCP addresses of same family are sent as unknown.
+type Header struct {
+	Version     Version
+	Source      net.Addr
+	Destination net.Addr
+	// TLVs are sent only in v2 header
+	TLVs []TLV
+}
+
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	v2VersionProxy = 0x21
+	v2FamilyUnspec = 0x00
+	v2FamilyTCP4   = 0x11
+	v2FamilyTCP6   = 0x21
+)
+
+// addrPorts returns source and destination as addresses of same family.
+func (h *Header) addrPorts() (src, dst netip.AddrPort, ok bool) {
+	tcpSrc, ok1 := h.Source.(*net.TCPAddr)
+	tcpDst, ok2 := h.Destination.(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		return
+	}
+	src, dst = tcpSrc.AddrPort(), tcpDst.AddrPort()
+	src = netip.AddrPortFrom(src.Addr().Unmap(), src.Port())
+	dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+	if src.Addr().Is4() != dst.Addr().Is4() {
+		return src, dst, false
+	}
+	return src, dst, true
+}
+
+func (h *Header) Format() ([]byte, error) {
+	switch h.Version {
+	case V1:
+		return h.formatV1(), nil
+	case V2:
+		return h.formatV2()
+	default:
+		return nil, fmt.Errorf("can't format PROXY header of version %v", h.Version)
+	}
+}
+
+func (h *Header) formatV1() []byte {
+	src, dst, ok := h.addrPorts()
+	if !ok {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	proto := "TCP4"
+	if src.Addr().Is6() {
+		proto = "TCP6"
+	}
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n",
+		proto, src.Addr(), dst.Addr(), src.Port(), dst.Port()))
+}
+
+func (h *Header) formatV2() ([]byte, error) {
+	var payload bytes.Buffer
+	family := byte(v2FamilyUnspec)
+	if src, dst, ok := h.addrPorts(); ok {
+		family = v2FamilyTCP4
+		if src.Addr().Is6() {
+			family = v2FamilyTCP6
+		}
+		payload.Write(src.Addr().AsSlice())
+		payload.Write(dst.Addr().AsSlice())
+		binary.Write(&payload, binary.BigEndian, src.Port())
+		binary.Write(&payload, binary.BigEndian, dst.Port())
+	}
+	for _, tlv := range h.TLVs {
+		if len(tlv.Value) > 0xffff {
+			return nil, errors.New("PROXY header TLV value is too long")
+		}
+		payload.WriteByte(tlv.Type)
+		binary.Write(&payload, binary.BigEndian, uint16(len(tlv.Value)))
+		payload.Write(tlv.Value)
+	}
+	if payload.Len() > 0xffff {
+		return nil, errors.New("PROXY header is too long")
+	}
+	res := make([]byte, 0, len(v2Signature)+4+payload.Len())
+	res = append(res, v2Signature...)
+	res = append(res, v2VersionProxy, family)
+	res = binary.BigEndian.AppendUint16(res, uint16(payload.Len()))
+	return append(res, payload.Bytes()...), nil
+}
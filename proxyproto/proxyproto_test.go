@@ -0,0 +1,65 @@
+package proxyproto
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func tcpAddr(s string) *net.TCPAddr {
+	addr, err := net.ResolveTCPAddr("tcp", s)
+	if err != nil {
+		panic(err)
+	}
+	return addr
+}
+
+func TestFormatV1(t *testing.T) {
+	for _, tc := range []struct {
+		src, dst net.Addr
+		want     string
+	}{
+		{tcpAddr("192.0.2.1:40000"), tcpAddr("127.0.0.1:57800"), "PROXY TCP4 192.0.2.1 127.0.0.1 40000 57800\r\n"},
+		{tcpAddr("[2001:db8::1]:40000"), tcpAddr("[::1]:57800"), "PROXY TCP6 2001:db8::1 ::1 40000 57800\r\n"},
+		{tcpAddr("[::ffff:192.0.2.1]:1"), tcpAddr("127.0.0.1:2"), "PROXY TCP4 192.0.2.1 127.0.0.1 1 2\r\n"},
+		{tcpAddr("[2001:db8::1]:1"), tcpAddr("127.0.0.1:2"), "PROXY UNKNOWN\r\n"},
+		{&net.UnixAddr{Name: "@", Net: "unix"}, &net.UnixAddr{Name: "/run/sock", Net: "unix"}, "PROXY UNKNOWN\r\n"},
+	} {
+		h := Header{Version: V1, Source: tc.src, Destination: tc.dst}
+		got, err := h.Format()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestFormatV2(t *testing.T) {
+	h := Header{
+		Version:     V2,
+		Source:      tcpAddr("192.0.2.1:40000"),
+		Destination: tcpAddr("127.0.0.1:57800"),
+		TLVs:        []TLV{{Type: TypeMinCustom, Value: []byte("svc")}},
+	}
+	got, err := h.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x12" +
+		"\xc0\x00\x02\x01\x7f\x00\x00\x01\x9c\x40\xe1\xc8" +
+		"\xe0\x00\x03svc")
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	h = Header{Version: V2, Source: &net.UnixAddr{}, Destination: &net.UnixAddr{}}
+	got, err = h.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("\r\n\r\n\x00\r\nQUIT\n\x21\x00\x00\x00"); !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+}
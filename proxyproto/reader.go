@@ -0,0 +1,140 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// maxV1Length is maximal length of v1 header including CRLF
+const maxV1Length = 107
+
+var ErrNoHeader = errors.New("no PROXY protocol header")
+
+// Read parses v1 or v2 header. Source and Destination of returned header
+// are nil if addresses are unknown, including v2 LOCAL command.
+func Read(r *bufio.Reader) (*Header, error) {
+	prefix, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(prefix, v2Signature):
+		return readV2(r)
+	case bytes.HasPrefix(prefix, []byte("PROXY ")):
+		return readV1(r)
+	default:
+		return nil, ErrNoHeader
+	}
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxV1Length {
+			return nil, errors.New("PROXY v1 header is too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	h := &Header{Version: V1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, nil
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", line)
+	}
+	src, err := parseV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func parseV1Addr(proto, ip, port string) (*net.TCPAddr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("bad address in PROXY v1 header: %w", err)
+	}
+	if addr.Is4() != (proto == "TCP4") {
+		return nil, fmt.Errorf("address %s doesn't match protocol %s in PROXY v1 header", addr, proto)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad port in PROXY v1 header: %w", err)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	verCmd, family := fixed[12], fixed[13]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY v2 header version %d", verCmd>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	h := &Header{Version: V2}
+	var addrLen int
+	switch family {
+	case v2FamilyTCP4:
+		addrLen = 12
+	case v2FamilyTCP6:
+		addrLen = 36
+	}
+	if len(payload) < addrLen {
+		return nil, errors.New("PROXY v2 header is truncated")
+	}
+	switch verCmd & 0xf {
+	case 0: // LOCAL
+		addrLen = 0
+	case 1: // PROXY
+		if addrLen > 0 {
+			ipLen := (addrLen - 4) / 2
+			src, _ := netip.AddrFromSlice(payload[:ipLen])
+			dst, _ := netip.AddrFromSlice(payload[ipLen : 2*ipLen])
+			ports := payload[2*ipLen:]
+			h.Source = net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, binary.BigEndian.Uint16(ports)))
+			h.Destination = net.TCPAddrFromAddrPort(netip.AddrPortFrom(dst, binary.BigEndian.Uint16(ports[2:])))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 command %d", verCmd&0xf)
+	}
+	// Addresses of other families are skipped along with TLVs
+	if addrLen == 0 && family != v2FamilyUnspec {
+		return h, nil
+	}
+	for tlvs := payload[addrLen:]; len(tlvs) > 0; {
+		if len(tlvs) < 3 {
+			return nil, errors.New("PROXY v2 TLV is truncated")
+		}
+		n := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+n {
+			return nil, errors.New("PROXY v2 TLV is truncated")
+		}
+		h.TLVs = append(h.TLVs, TLV{Type: tlvs[0], Value: tlvs[3 : 3+n]})
+		tlvs = tlvs[3+n:]
+	}
+	return h, nil
+}
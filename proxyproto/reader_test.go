@@ -0,0 +1,73 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadRoundTrip(t *testing.T) {
+	for _, h := range []Header{
+		{Version: V1, Source: tcpAddr("192.0.2.1:40000"), Destination: tcpAddr("127.0.0.1:57800")},
+		{Version: V1, Source: tcpAddr("[2001:db8::1]:40000"), Destination: tcpAddr("[::1]:57800")},
+		{Version: V2, Source: tcpAddr("192.0.2.1:40000"), Destination: tcpAddr("127.0.0.1:57800"),
+			TLVs: []TLV{{Type: TypeMinCustom, Value: []byte("svc")}}},
+		{Version: V2, Source: tcpAddr("[2001:db8::1]:40000"), Destination: tcpAddr("[::1]:57800")},
+	} {
+		buf, err := h.Format()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := bufio.NewReader(io.MultiReader(bytes.NewReader(buf), strings.NewReader("payload")))
+		got, err := Read(r)
+		if err != nil {
+			t.Fatalf("%q: %v", buf, err)
+		}
+		if got.Source.String() != h.Source.String() || got.Destination.String() != h.Destination.String() ||
+			len(got.TLVs) != len(h.TLVs) {
+			t.Errorf("%q: parsed as %+v", buf, got)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != "payload" {
+			t.Errorf("%q: data after header is %q", buf, rest)
+		}
+	}
+}
+
+func TestReadUnknown(t *testing.T) {
+	for _, header := range []string{
+		"PROXY UNKNOWN\r\n",
+		"PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n",
+		// v2 LOCAL command used by health checks
+		"\r\n\r\n\x00\r\nQUIT\n\x20\x11\x00\x0c\x7f\x00\x00\x01\x7f\x00\x00\x01\x00\x01\x00\x02",
+	} {
+		h, err := Read(bufio.NewReader(strings.NewReader(header)))
+		if err != nil {
+			t.Fatalf("%q: %v", header, err)
+		}
+		if h.Source != nil || h.Destination != nil {
+			t.Errorf("%q: unexpected addresses %v %v", header, h.Source, h.Destination)
+		}
+	}
+}
+
+func TestReadMalformed(t *testing.T) {
+	for _, header := range []string{
+		"GET / HTTP/1.1\r\n\r\n",
+		"PROXY TCP4 192.0.2.1 127.0.0.1 40000\r\n",
+		"PROXY TCP4 2001:db8::1 127.0.0.1 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 127.0.0.1 1 70000\r\n",
+		"PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n",
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x04\x7f\x00\x00\x01",
+		"\r\n\r\n\x00\r\nQUIT\n\x11\x11\x00\x00",
+	} {
+		if _, err := Read(bufio.NewReader(strings.NewReader(header))); err == nil {
+			t.Errorf("malformed header %q accepted", header)
+		}
+	}
+	if _, err := Read(bufio.NewReader(strings.NewReader("SSH-2.0-OpenSSH\r\n"))); !errors.Is(err, ErrNoHeader) {
+		t.Errorf("expected ErrNoHeader, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/huandu/skiplist"
+)
+
+const MaxUint = ^uint(0)
+const WrapTreshold = (MaxUint >> 1) + 1
+
+// skiplistQueue is former LSN-keyed queue implementation kept here
+// for comparison.
+type skiplistQueue struct {
+	l       *skiplist.SkipList
+	cur_lsn uint
+}
+
+func newSkiplistQueue() *skiplistQueue {
+	return &skiplistQueue{
+		l: skiplist.New(skiplist.GreaterThanFunc(func(lhs, rhs interface{}) int {
+			x, y := lhs.(uint), rhs.(uint)
+			switch {
+			case x == y:
+				return 0
+			case (x < y && (y-x) <= WrapTreshold) || (x > y && (x-y) > WrapTreshold):
+				return -1
+			default:
+				return 1
+			}
+		})),
+	}
+}
+
+func (q *skiplistQueue) Push(e interface{}) uint {
+	lsn := q.cur_lsn
+	q.cur_lsn++
+	q.l.Set(lsn, e)
+	return lsn
+}
+
+func (q *skiplistQueue) Pop() interface{} {
+	if q.l.Len() == 0 {
+		return nil
+	}
+	return q.l.RemoveFront().Value
+}
+
+func (q *skiplistQueue) Delete(key uint) interface{} {
+	elem := q.l.Remove(key)
+	if elem == nil {
+		return nil
+	}
+	return elem.Value
+}
+
+const benchQueueSize = 256
+
+func BenchmarkRAQueuePushPop(b *testing.B) {
+	q := NewRAQueue[chan struct{}]()
+	ch := make(chan struct{})
+	for i := 0; i < benchQueueSize; i++ {
+		q.Push(ch)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.Push(ch)
+		q.Pop()
+	}
+}
+
+func BenchmarkSkiplistPushPop(b *testing.B) {
+	q := newSkiplistQueue()
+	ch := make(chan struct{})
+	for i := 0; i < benchQueueSize; i++ {
+		q.Push(ch)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.Push(ch)
+		q.Pop()
+	}
+}
+
+func BenchmarkRAQueuePushDelete(b *testing.B) {
+	q := NewRAQueue[chan struct{}]()
+	ch := make(chan struct{})
+	handles := make([]*Handle[chan struct{}], benchQueueSize)
+	for i := range handles {
+		handles[i] = q.Push(ch)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := i % benchQueueSize
+		q.Delete(handles[j])
+		handles[j] = q.Push(ch)
+	}
+}
+
+func BenchmarkSkiplistPushDelete(b *testing.B) {
+	q := newSkiplistQueue()
+	ch := make(chan struct{})
+	handles := make([]uint, benchQueueSize)
+	for i := range handles {
+		handles[i] = q.Push(ch)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := i % benchQueueSize
+		q.Delete(handles[j])
+		handles[j] = q.Push(ch)
+	}
+}
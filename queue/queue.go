@@ -1,52 +1,93 @@
 package queue
 
 import (
-	"github.com/huandu/skiplist"
+	"iter"
 )
 
-const MaxUint = ^uint(0)
-const WrapTreshold = (MaxUint >> 1) + 1
-
-type RAQueue struct {
-	l       *skiplist.SkipList
-	cur_lsn uint
-}
-
-func NewRAQueue() *RAQueue {
-	return &RAQueue{
-		l: skiplist.New(skiplist.GreaterThanFunc(func(lhs, rhs interface{}) int {
-			x, y := lhs.(uint), rhs.(uint)
-			switch {
-			case x == y:
-				return 0
-			case (x < y && (y-x) <= WrapTreshold) || (x > y && (x-y) > WrapTreshold):
-				return -1
-			default:
-				return 1
-			}
-		})),
+// Handle refers to element pushed into RAQueue and allows to remove
+// it from arbitrary position in constant time.
+type Handle[T any] struct {
+	prev, next *Handle[T]
+	queue      *RAQueue[T]
+	value      T
+}
+
+type RAQueue[T any] struct {
+	root Handle[T]
+	len  int
+}
+
+func NewRAQueue[T any]() *RAQueue[T] {
+	q := &RAQueue[T]{}
+	q.root.prev = &q.root
+	q.root.next = &q.root
+	return q
+}
+
+func (q *RAQueue[T]) Len() int {
+	return q.len
+}
+
+func (q *RAQueue[T]) Push(e T) *Handle[T] {
+	h := &Handle[T]{
+		prev:  q.root.prev,
+		next:  &q.root,
+		queue: q,
+		value: e,
+	}
+	h.prev.next = h
+	q.root.prev = h
+	q.len++
+	return h
+}
+
+func (q *RAQueue[T]) remove(h *Handle[T]) T {
+	h.prev.next = h.next
+	h.next.prev = h.prev
+	h.prev = nil
+	h.next = nil
+	h.queue = nil
+	q.len--
+	value := h.value
+	var zero T
+	h.value = zero
+	return value
+}
+
+func (q *RAQueue[T]) Pop() (T, bool) {
+	if q.len == 0 {
+		var zero T
+		return zero, false
 	}
+	return q.remove(q.root.next), true
 }
 
-func (q *RAQueue) Push(e interface{}) uint {
-	lsn := q.cur_lsn
-	q.cur_lsn++
-	q.l.Set(lsn, e)
-	return lsn
+func (q *RAQueue[T]) PopBack() (T, bool) {
+	if q.len == 0 {
+		var zero T
+		return zero, false
+	}
+	return q.remove(q.root.prev), true
 }
 
-func (q *RAQueue) Pop() interface{} {
-	if q.l.Len() == 0 {
-		return nil
+// Delete removes element referenced by handle. It returns false if
+// element was already removed from queue.
+func (q *RAQueue[T]) Delete(h *Handle[T]) (T, bool) {
+	if h == nil || h.queue != q {
+		var zero T
+		return zero, false
 	}
-	return q.l.RemoveFront().Value
+	return q.remove(h), true
 }
 
-func (q *RAQueue) Delete(key uint) interface{} {
-	elem := q.l.Remove(key)
-	if elem == nil {
-		return nil
-	} else {
-		return elem.Value
+// All iterates over queued elements from front to back. Queue must not
+// be modified during iteration.
+func (q *RAQueue[T]) All() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for h := q.root.next; h != &q.root; h = h.next {
+			if !yield(h.value) {
+				return
+			}
+		}
 	}
 }
@@ -1,47 +1,95 @@
 package queue
 
 import (
+	"slices"
 	"testing"
 )
 
 func TestPushPop(t *testing.T) {
-	queue := NewRAQueue()
+	queue := NewRAQueue[string]()
 	data := []string{"first", "second", "third"}
 	for _, str := range data {
 		queue.Push(str)
 	}
 
 	for _, str := range data {
-		if queue.Pop().(string) != str {
+		if v, ok := queue.Pop(); !ok || v != str {
 			t.Fail()
 		}
 	}
 
-	if queue.Pop() != nil {
+	if _, ok := queue.Pop(); ok {
+		t.Fail()
+	}
+}
+
+func TestPopBack(t *testing.T) {
+	queue := NewRAQueue[string]()
+	data := []string{"first", "second", "third"}
+	for _, str := range data {
+		queue.Push(str)
+	}
+
+	for i := len(data) - 1; i >= 0; i-- {
+		if v, ok := queue.PopBack(); !ok || v != data[i] {
+			t.Fail()
+		}
+	}
+
+	if _, ok := queue.PopBack(); ok {
 		t.Fail()
 	}
 }
 
 func TestDelete(t *testing.T) {
-	queue := NewRAQueue()
+	queue := NewRAQueue[string]()
 	data := []string{"first", "second", "third"}
-	idx := make([]uint, 3)
+	idx := make([]*Handle[string], 3)
 	for i, str := range data {
 		idx[i] = queue.Push(str)
 	}
 
-	if queue.Delete(idx[1]).(string) != "second" {
+	if v, ok := queue.Delete(idx[1]); !ok || v != "second" {
+		t.Fail()
+	}
+
+	if _, ok := queue.Delete(idx[1]); ok {
+		t.Fail()
+	}
+
+	if queue.Len() != 2 {
+		t.Fail()
+	}
+
+	if !slices.Equal(slices.Collect(queue.All()), []string{"first", "third"}) {
 		t.Fail()
 	}
 
 	data = []string{"first", "third"}
 	for _, str := range data {
-		if queue.Pop().(string) != str {
+		if v, ok := queue.Pop(); !ok || v != str {
 			t.Fail()
 		}
 	}
 
-	if queue.Pop() != nil {
+	if _, ok := queue.Pop(); ok {
+		t.Fail()
+	}
+
+	if _, ok := queue.Delete(idx[0]); ok {
+		t.Fail()
+	}
+}
+
+func TestDeleteForeign(t *testing.T) {
+	q1 := NewRAQueue[int]()
+	q2 := NewRAQueue[int]()
+	h := q1.Push(1)
+	q2.Push(2)
+	if _, ok := q2.Delete(h); ok {
+		t.Fail()
+	}
+	if q1.Len() != 1 || q2.Len() != 1 {
 		t.Fail()
 	}
 }
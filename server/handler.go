@@ -8,15 +8,66 @@ import (
 
 	clog "github.com/Snawoot/steady-tun/log"
 	"github.com/Snawoot/steady-tun/pool"
+	"github.com/Snawoot/steady-tun/proxyproto"
 )
 
+// Route directs clients matching Rule to separate pool.
+type Route struct {
+	Name string
+	Rule IdentityRule
+	Pool *pool.ConnPool
+	// ProxyHeader selects PROXY protocol header sent to upstream
+	ProxyHeader proxyproto.Version
+	// ProxyTLVs adds route name to v2 header as custom TLV
+	ProxyTLVs bool
+}
+
 type ConnHandler struct {
-	pool   *pool.ConnPool
-	logger *clog.CondLogger
+	defaultRoute Route
+	routes       []Route
+	logger       *clog.CondLogger
 }
 
-func NewConnHandler(pool *pool.ConnPool, logger *clog.CondLogger) *ConnHandler {
-	return &ConnHandler{pool, logger}
+// NewConnHandler creates handler which serves clients using first
+// matching route or default route. Rule of default route is ignored.
+func NewConnHandler(defaultRoute Route, routes []Route, logger *clog.CondLogger) *ConnHandler {
+	return &ConnHandler{defaultRoute, routes, logger}
+}
+
+func (h *ConnHandler) selectRoute(ctx context.Context, c net.Conn) *Route {
+	if len(h.routes) == 0 {
+		return &h.defaultRoute
+	}
+	id := identify(ctx, c)
+	for i := range h.routes {
+		if h.routes[i].Rule.Match(id) {
+			h.logger.Debug("Client %s matched identity %q", id, h.routes[i].Name)
+			return &h.routes[i]
+		}
+	}
+	h.logger.Debug("Client %s matched no identity, using default pool", id)
+	return &h.defaultRoute
+}
+
+// sendProxyHeader passes address of local client to upstream
+func (h *ConnHandler) sendProxyHeader(route *Route, client, upstream net.Conn) error {
+	header := proxyproto.Header{
+		Version:     route.ProxyHeader,
+		Source:      client.RemoteAddr(),
+		Destination: client.LocalAddr(),
+	}
+	if route.ProxyTLVs && route.Name != "" {
+		header.TLVs = append(header.TLVs, proxyproto.TLV{
+			Type:  proxyproto.TypeMinCustom,
+			Value: []byte(route.Name),
+		})
+	}
+	buf, err := header.Format()
+	if err != nil {
+		return err
+	}
+	_, err = upstream.Write(buf)
+	return err
 }
 
 func (h *ConnHandler) proxy(ctx context.Context, left, right net.Conn) {
@@ -51,11 +102,20 @@ func (h *ConnHandler) Handle(ctx context.Context, c net.Conn) {
 	h.logger.Info("Got new connection from %s", remote_addr)
 	defer h.logger.Info("Connection %s done", remote_addr)
 
-	tlsconn, err := h.pool.Get(ctx)
+	route := h.selectRoute(ctx, c)
+	tlsconn, err := route.Pool.Get(ctx)
 	if err != nil {
 		h.logger.Error("Error on connection retrieve from pool: %v", err)
 		c.Close()
 		return
 	}
+	if route.ProxyHeader != proxyproto.None {
+		if err := h.sendProxyHeader(route, c, tlsconn); err != nil {
+			h.logger.Error("Unable to send PROXY header to upstream: %v", err)
+			c.Close()
+			tlsconn.Close()
+			return
+		}
+	}
 	h.proxy(ctx, c, tlsconn)
 }
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ClientIdentity describes local client of tunnel.
+type ClientIdentity struct {
+	// Listener is address of listener which accepted connection
+	Listener string
+	// Addr is source address of TCP connection. It is invalid for unix
+	// socket connections.
+	Addr netip.AddrPort
+	// UID is peer user ID of unix socket connection or -1
+	UID int
+}
+
+func identify(ctx context.Context, c net.Conn) ClientIdentity {
+	id := ClientIdentity{
+		Listener: listenerName(ctx),
+		UID:      peerUID(c),
+	}
+	if addr, err := netip.ParseAddrPort(c.RemoteAddr().String()); err == nil {
+		id.Addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+	}
+	return id
+}
+
+func (id ClientIdentity) String() string {
+	var parts []string
+	if id.Addr.IsValid() {
+		parts = append(parts, "src="+id.Addr.String())
+	}
+	if id.UID >= 0 {
+		parts = append(parts, "uid="+strconv.Itoa(id.UID))
+	}
+	parts = append(parts, "listener="+id.Listener)
+	return strings.Join(parts, ",")
+}
+
+// IdentityRule matches client identity. Zero fields match anything.
+type IdentityRule struct {
+	Listener         string
+	Prefix           netip.Prefix
+	MinPort, MaxPort uint16
+	// UID of -1 matches any user
+	UID int
+}
+
+// ParseIdentityRule builds rule from comma-separated key=value pairs:
+// src=CIDR or IP, ports=N or N-M, uid=N, listener=ADDRESS.
+func ParseIdentityRule(s string) (IdentityRule, error) {
+	rule := IdentityRule{UID: -1}
+	if s == "" {
+		return rule, errors.New("empty client identity rule")
+	}
+	for _, kv := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return rule, fmt.Errorf("bad client identity rule parameter %q", kv)
+		}
+		var err error
+		switch strings.TrimSpace(key) {
+		case "src":
+			if strings.Contains(value, "/") {
+				rule.Prefix, err = netip.ParsePrefix(value)
+			} else {
+				var addr netip.Addr
+				addr, err = netip.ParseAddr(value)
+				rule.Prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			rule.Prefix = rule.Prefix.Masked()
+		case "ports":
+			lo, hi, isRange := strings.Cut(value, "-")
+			if !isRange {
+				hi = lo
+			}
+			var first, last uint64
+			if first, err = strconv.ParseUint(lo, 10, 16); err != nil {
+				break
+			}
+			if last, err = strconv.ParseUint(hi, 10, 16); err != nil {
+				break
+			}
+			if first > last {
+				err = errors.New("empty range")
+			}
+			rule.MinPort, rule.MaxPort = uint16(first), uint16(last)
+		case "uid":
+			rule.UID, err = strconv.Atoi(value)
+			if err == nil && rule.UID < 0 {
+				err = errors.New("negative UID")
+			}
+		case "listener":
+			rule.Listener = value
+		default:
+			return rule, fmt.Errorf("unknown client identity rule parameter %q", key)
+		}
+		if err != nil {
+			return rule, fmt.Errorf("bad client identity rule value %q: %w", kv, err)
+		}
+	}
+	return rule, nil
+}
+
+func (r IdentityRule) Match(id ClientIdentity) bool {
+	if r.Listener != "" && r.Listener != id.Listener {
+		return false
+	}
+	if r.Prefix.IsValid() && !(id.Addr.IsValid() && r.Prefix.Contains(id.Addr.Addr())) {
+		return false
+	}
+	if r.MaxPort != 0 && !(id.Addr.IsValid() && id.Addr.Port() >= r.MinPort && id.Addr.Port() <= r.MaxPort) {
+		return false
+	}
+	if r.UID >= 0 && r.UID != id.UID {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestIdentityRule(t *testing.T) {
+	tcpClient := ClientIdentity{
+		Listener: "127.0.0.1:57800",
+		Addr:     netip.MustParseAddrPort("10.1.2.3:40000"),
+		UID:      -1,
+	}
+	unixClient := ClientIdentity{
+		Listener: "/run/steady-tun.sock",
+		UID:      1000,
+	}
+	for _, tc := range []struct {
+		rule      string
+		tcp, unix bool
+	}{
+		{"src=10.1.0.0/16", true, false},
+		{"src=10.1.2.3", true, false},
+		{"src=10.2.0.0/16", false, false},
+		{"src=10.1.0.0/16,ports=40000-40100", true, false},
+		{"ports=1-1024", false, false},
+		{"uid=1000", false, true},
+		{"uid=1001", false, false},
+		{"listener=/run/steady-tun.sock", false, true},
+		{"listener=127.0.0.1:57800", true, false},
+	} {
+		rule, err := ParseIdentityRule(tc.rule)
+		if err != nil {
+			t.Fatalf("rule %q: %v", tc.rule, err)
+		}
+		if rule.Match(tcpClient) != tc.tcp || rule.Match(unixClient) != tc.unix {
+			t.Errorf("rule %q: unexpected match result", tc.rule)
+		}
+	}
+	for _, bad := range []string{"", "src=bogus", "ports=10-1", "uid=-1", "color=red", "src"} {
+		if _, err := ParseIdentityRule(bad); err == nil {
+			t.Errorf("bad rule %q accepted", bad)
+		}
+	}
+}
@@ -10,24 +10,31 @@ import (
 
 type HandlerFunc func(context.Context, net.Conn)
 
-type TCPListener struct {
-	address    string
-	port       uint16
-	handler    HandlerFunc
+type listenerKey struct{}
+
+// listenerName returns address of listener which accepted connection
+// handled within ctx.
+func listenerName(ctx context.Context) string {
+	name, _ := ctx.Value(listenerKey{}).(string)
+	return name
+}
+
+// acceptor runs accept loop and tracks handlers for graceful shutdown.
+type acceptor struct {
+	handler HandlerFunc
+	// prepare is called in handler goroutine before handler
+	prepare    func(net.Conn) (net.Conn, error)
 	quitaccept chan struct{}
-	listener   *net.TCPListener
+	listener   net.Listener
 	logger     *clog.CondLogger
 	ctx        context.Context
 	cancel     context.CancelFunc
 	shutdown   sync.WaitGroup
 }
 
-func NewTCPListener(address string, port uint16, handler HandlerFunc,
-	logger *clog.CondLogger) *TCPListener {
+func newAcceptor(handler HandlerFunc, logger *clog.CondLogger) acceptor {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &TCPListener{
-		address:    address,
-		port:       port,
+	return acceptor{
 		handler:    handler,
 		logger:     logger,
 		quitaccept: make(chan struct{}, 1),
@@ -36,24 +43,13 @@ func NewTCPListener(address string, port uint16, handler HandlerFunc,
 	}
 }
 
-func (l *TCPListener) Start() error {
-	ips, err := net.LookupIP(l.address)
-	if err != nil {
-		return err
-	}
-	listener, err := net.ListenTCP("tcp", &net.TCPAddr{
-		IP:   ips[0],
-		Port: int(l.port),
-	})
-	if err != nil {
-		return err
-	}
+func (l *acceptor) start(listener net.Listener, name string) {
 	l.listener = listener
+	l.ctx = context.WithValue(l.ctx, listenerKey{}, name)
 	go l.serve()
-	return nil
 }
 
-func (l *TCPListener) serve() {
+func (l *acceptor) serve() {
 	for {
 		conn, err := l.listener.Accept()
 		if err != nil {
@@ -70,15 +66,66 @@ func (l *TCPListener) serve() {
 		l.shutdown.Add(1)
 		go func(c net.Conn) {
 			defer l.shutdown.Done()
+			if l.prepare != nil {
+				prepared, err := l.prepare(c)
+				if err != nil {
+					l.logger.Error("Rejecting connection: %v", err)
+					c.Close()
+					return
+				}
+				c = prepared
+			}
 			l.handler(l.ctx, c)
 		}(conn)
 	}
 }
 
-func (l *TCPListener) Stop() {
+func (l *acceptor) Stop() {
 	l.quitaccept <- struct{}{}
 	l.listener.Close()
 	<-l.quitaccept
 	l.cancel()
 	l.shutdown.Wait()
 }
+
+type TCPListener struct {
+	acceptor
+	address string
+	port    uint16
+	proxy   *ProxyProtocolOptions
+}
+
+// NewTCPListener creates listener. If proxyOpts is not nil, PROXY
+// protocol header is expected from trusted sources.
+func NewTCPListener(address string, port uint16, proxyOpts *ProxyProtocolOptions, handler HandlerFunc,
+	logger *clog.CondLogger) *TCPListener {
+	l := &TCPListener{
+		acceptor: newAcceptor(handler, logger),
+		address:  address,
+		port:     port,
+		proxy:    proxyOpts,
+	}
+	if proxyOpts != nil {
+		l.prepare = proxyOpts.acceptProxy
+	}
+	return l
+}
+
+func (l *TCPListener) Start() error {
+	if l.proxy != nil && len(l.proxy.Trusted) == 0 {
+		return errNoTrusted
+	}
+	ips, err := net.LookupIP(l.address)
+	if err != nil {
+		return err
+	}
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{
+		IP:   ips[0],
+		Port: int(l.port),
+	})
+	if err != nil {
+		return err
+	}
+	l.start(listener, listener.Addr().String())
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package server
+
+import "net"
+
+// peerUID returns -1 since SO_PEERCRED is not available on this platform.
+func peerUID(_ net.Conn) int {
+	return -1
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/Snawoot/steady-tun/proxyproto"
+)
+
+// ProxyProtocolOptions enable parsing of PROXY protocol header sent by
+// load balancer in front of listener.
+type ProxyProtocolOptions struct {
+	// Trusted lists networks of load balancers. Header is required from
+	// them and ignored from other sources.
+	Trusted []netip.Prefix
+	// Timeout limits time to receive header
+	Timeout time.Duration
+}
+
+func (o *ProxyProtocolOptions) trusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip := tcpAddr.AddrPort().Addr().Unmap()
+	for _, prefix := range o.Trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxiedConn reports addresses recovered from PROXY header.
+type proxiedConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *proxiedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *proxiedConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// acceptProxy reads PROXY header from trusted sources and returns
+// connection with original client addresses.
+func (o *ProxyProtocolOptions) acceptProxy(c net.Conn) (net.Conn, error) {
+	if !o.trusted(c.RemoteAddr()) {
+		return c, nil
+	}
+	if o.Timeout > 0 {
+		c.SetReadDeadline(time.Now().Add(o.Timeout))
+	}
+	r := bufio.NewReader(c)
+	header, err := proxyproto.Read(r)
+	if err != nil {
+		return nil, fmt.Errorf("bad PROXY protocol header from %s: %w", c.RemoteAddr(), err)
+	}
+	if err := c.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	pc := &proxiedConn{
+		Conn:   c,
+		r:      r,
+		remote: c.RemoteAddr(),
+		local:  c.LocalAddr(),
+	}
+	// Unknown addresses are used by health checks
+	if header.Source != nil && header.Destination != nil {
+		pc.remote, pc.local = header.Source, header.Destination
+	}
+	return pc, nil
+}
+
+var errNoTrusted = errors.New("PROXY protocol requires at least one trusted source network")
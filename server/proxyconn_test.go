@@ -0,0 +1,64 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestAcceptProxy(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accept := func(payload string) net.Conn {
+		t.Helper()
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		if payload != "" {
+			io.WriteString(client, payload)
+		}
+		c, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	trusted := &ProxyProtocolOptions{
+		Trusted: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		Timeout: 100 * time.Millisecond,
+	}
+
+	c, err := trusted.acceptProxy(accept("PROXY TCP4 192.0.2.1 192.0.2.2 40000 443\r\nhello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.RemoteAddr().String() != "192.0.2.1:40000" || c.LocalAddr().String() != "192.0.2.2:443" {
+		t.Fatalf("unexpected addresses %s -> %s", c.RemoteAddr(), c.LocalAddr())
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("unexpected data %q: %v", buf, err)
+	}
+
+	if _, err := trusted.acceptProxy(accept("hello, no header\r\n")); err == nil {
+		t.Fatal("connection without header accepted from trusted source")
+	}
+	if _, err := trusted.acceptProxy(accept("")); err == nil {
+		t.Fatal("connection accepted despite header timeout")
+	}
+
+	untrusted := &ProxyProtocolOptions{Trusted: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}}
+	raw := accept("PROXY TCP4 192.0.2.1 192.0.2.2 40000 443\r\n")
+	c, err = untrusted.acceptProxy(raw)
+	if err != nil || c != raw {
+		t.Fatalf("connection from untrusted source was altered: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+
+	clog "github.com/Snawoot/steady-tun/log"
+)
+
+// UnixListener accepts connections on unix domain socket. Peer UID of
+// such connections is available for client identity matching.
+type UnixListener struct {
+	acceptor
+	path string
+}
+
+func NewUnixListener(path string, handler HandlerFunc, logger *clog.CondLogger) *UnixListener {
+	return &UnixListener{
+		acceptor: newAcceptor(handler, logger),
+		path:     path,
+	}
+}
+
+func (l *UnixListener) Start() error {
+	// Remove socket left by unclean shutdown, but never regular files
+	if fi, err := os.Lstat(l.path); err == nil && fi.Mode().Type() == fs.ModeSocket {
+		os.Remove(l.path)
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: l.path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	l.start(listener, l.path)
+	return nil
+}